@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// card reader and punch registers
+const (
+	crdAddr    = 0 // memory address of card images
+	crdCount   = 1 // cards to transfer
+	crdCards   = 2 // cards transferred by last command
+	crdCommand = 3 // write starts transfer
+	crdControl = 4 // interrupt enable bits
+	crdStatus  = 5 // read: status bits, write: clear written status bits
+	crdSize    = 6
+)
+
+// card command
+const crdStart = 1
+
+// card status bits
+const (
+	crdDone  = 1 // transfer completed
+	crdError = 2 // bad command, memory address, character or host file error
+	crdEmpty = 4 // reader hopper is empty (end of deck)
+	crdBusy  = 8 // card images are being transferred
+)
+
+// card geometry: 80 columns of 12 punch rows, 4 columns per word,
+// first column in most significant bits
+const (
+	cardColumns = 80
+	cardWords   = cardColumns / 4
+)
+
+// deck file formats
+const (
+	deckText   = iota // one text line per card, Hollerith (IBM 029) code
+	deckBinary        // column binary: 2 bytes big endian per column
+)
+
+// hollerith returns punches of rows (12, 11, 0 .. 9), row 12 is bit 11
+// and row 9 is bit 0
+func hollerith(rows ...int) uint16 {
+	var code uint16
+	for _, row := range rows {
+		switch row {
+		case 12:
+			code |= 1 << 11
+		case 11:
+			code |= 1 << 10
+		default:
+			code |= 1 << uint(9-row)
+		}
+	}
+	return code
+}
+
+var (
+	cardCodes = map[rune]uint16{} // host character to column punches
+	cardChars = map[uint16]rune{} // column punches to host character
+)
+
+func init() {
+	add := func(r rune, rows ...int) {
+		code := hollerith(rows...)
+		cardCodes[r] = code
+		cardChars[code] = r
+	}
+	add(' ')
+	for i := 0; i < 10; i++ {
+		add(rune('0'+i), i)
+	}
+	for i := 1; i <= 9; i++ {
+		add(rune('A'+i-1), 12, i)
+		add(rune('J'+i-1), 11, i)
+	}
+	for i := 2; i <= 9; i++ {
+		add(rune('S'+i-2), 0, i)
+	}
+	add('&', 12)
+	add('-', 11)
+	add('/', 0, 1)
+	for i, r := range []rune("¢.<(+|") {
+		add(r, 12, 8, i+2)
+	}
+	for i, r := range []rune("!$*);¬") {
+		add(r, 11, 8, i+2)
+	}
+	for i, r := range []rune(",%_>?") {
+		add(r, 0, 8, i+3)
+	}
+	for i, r := range []rune(":#@'=\"") {
+		add(r, 8, i+2)
+	}
+}
+
+// cardRegs are registers common to card reader and punch
+type cardRegs struct {
+	name    string
+	ch      *Channel
+	format  int
+	addr    besmWord
+	count   besmWord
+	cards   besmWord
+	control besmWord
+	status  besmWord
+	IntLine
+}
+
+func (crd *cardRegs) reset() {
+	crd.addr = 0
+	crd.count = 0
+	crd.cards = 0
+	crd.control = 0
+	crd.status &= crdEmpty
+}
+
+func (crd *cardRegs) getName() string {
+	return crd.name
+}
+
+func (crd *cardRegs) getSize() uint16 {
+	return crdSize
+}
+
+func (crd *cardRegs) read(addr uint16) besmWord {
+	switch addr {
+	case crdAddr:
+		return crd.addr
+	case crdCount:
+		return crd.count
+	case crdCards:
+		return crd.cards
+	case crdControl:
+		return crd.control
+	case crdStatus:
+		return crd.status
+	}
+	return 0
+}
+
+// writeReg writes register, returns true if transfer is started
+func (crd *cardRegs) writeReg(addr uint16, value besmWord) bool {
+	switch addr {
+	case crdAddr:
+		crd.addr = value & MASK15
+	case crdCount:
+		crd.count = value & MASK15
+	case crdCommand:
+		if crd.status&crdBusy != 0 {
+			crd.status |= crdError
+			return false
+		}
+		crd.cards = 0
+		crd.status &^= crdDone | crdError
+		if value == crdStart {
+			return true
+		}
+		crd.complete(crdError)
+	case crdControl:
+		crd.control = value & (crdDone | crdError | crdEmpty)
+	case crdStatus:
+		crd.status &^= value
+	}
+	return false
+}
+
+func (crd *cardRegs) complete(status besmWord) {
+	crd.status &^= crdBusy
+	crd.status |= crdDone | status
+	if crd.control&(crdDone|status) != 0 {
+		crd.request()
+	}
+}
+
+// CardReader reads card deck from host file to memory
+type CardReader struct {
+	cardRegs
+	in *bufio.Reader
+}
+
+func newCardReader(name string, in io.Reader, ch *Channel, format int) CardReader {
+	return CardReader{cardRegs{name: name, ch: ch, format: format}, bufio.NewReader(in)}
+}
+
+func (rdr *CardReader) write(addr uint16, value besmWord) {
+	if !rdr.writeReg(addr, value) {
+		return
+	}
+	var status besmWord
+	var words []besmWord
+	for rdr.cards < rdr.count && status == 0 {
+		card, ok, st := rdr.readCard()
+		status = st
+		if !ok {
+			break
+		}
+		words = append(words, packCard(card)...)
+		rdr.cards++
+	}
+	if len(words) == 0 {
+		rdr.complete(status)
+		return
+	}
+	rdr.status |= crdBusy
+	rdr.ch.toMemory(uint16(rdr.addr), words, func(ok bool) {
+		if !ok {
+			status |= crdError
+		}
+		rdr.complete(status)
+	})
+}
+
+// readCard reads next card from deck, returns false at end of deck
+func (rdr *CardReader) readCard() ([cardColumns]uint16, bool, besmWord) {
+	var card [cardColumns]uint16
+	var status besmWord
+	if rdr.format == deckBinary {
+		var buf [2 * cardColumns]byte
+		if _, err := io.ReadFull(rdr.in, buf[:]); err != nil {
+			if err == io.EOF {
+				return card, false, crdEmpty
+			}
+			return card, false, crdEmpty | crdError
+		}
+		for i := range card {
+			card[i] = (uint16(buf[2*i])<<8 | uint16(buf[2*i+1])) & 0o7777
+		}
+		return card, true, 0
+	}
+	text, err := rdr.in.ReadString('\n')
+	if text == "" && err != nil {
+		if err == io.EOF {
+			return card, false, crdEmpty
+		}
+		return card, false, crdEmpty | crdError
+	}
+	text = strings.TrimRight(text, "\r\n")
+	for i, r := range []rune(text) {
+		if i >= cardColumns {
+			break
+		}
+		code, ok := cardCodes[unicode.ToUpper(r)]
+		if !ok {
+			status = crdError
+		}
+		card[i] = code
+	}
+	return card, true, status
+}
+
+// CardPunch punches cards from memory to host deck file
+type CardPunch struct {
+	cardRegs
+	out io.Writer
+}
+
+func newCardPunch(name string, out io.Writer, ch *Channel, format int) CardPunch {
+	return CardPunch{cardRegs{name: name, ch: ch, format: format}, out}
+}
+
+func (pun *CardPunch) write(addr uint16, value besmWord) {
+	if !pun.writeReg(addr, value) {
+		return
+	}
+	pun.status |= crdBusy
+	pun.ch.fromMemory(uint16(pun.addr), int(pun.count)*cardWords, func(words []besmWord, ok bool) {
+		if !ok {
+			pun.complete(crdError)
+			return
+		}
+		var status besmWord
+		for len(words) != 0 && status == 0 {
+			status = pun.punchCard(unpackCard(words[:cardWords]))
+			words = words[cardWords:]
+			pun.cards++
+		}
+		pun.complete(status)
+	})
+}
+
+// punchCard writes card to deck, columns without character are punched
+// as spaces in text deck
+func (pun *CardPunch) punchCard(card [cardColumns]uint16) besmWord {
+	var status besmWord
+	var err error
+	if pun.format == deckBinary {
+		var buf [2 * cardColumns]byte
+		for i, code := range card {
+			buf[2*i] = byte(code >> 8)
+			buf[2*i+1] = byte(code)
+		}
+		_, err = pun.out.Write(buf[:])
+	} else {
+		var sb strings.Builder
+		for _, code := range card {
+			r, ok := cardChars[code]
+			if !ok {
+				r = ' '
+				status = crdError
+			}
+			sb.WriteRune(r)
+		}
+		_, err = io.WriteString(pun.out, strings.TrimRight(sb.String(), " ")+"\n")
+	}
+	if err != nil {
+		status = crdError
+	}
+	return status
+}
+
+// packCard returns memory image of card
+func packCard(card [cardColumns]uint16) []besmWord {
+	words := make([]besmWord, cardWords)
+	for i := range words {
+		for _, code := range card[4*i : 4*i+4] {
+			words[i] = words[i]<<12 | besmWord(code)
+		}
+	}
+	return words
+}
+
+// unpackCard returns card of memory image
+func unpackCard(words []besmWord) [cardColumns]uint16 {
+	var card [cardColumns]uint16
+	for i, word := range words {
+		for j := 3; j >= 0; j-- {
+			card[4*i+j] = uint16(word & 0o7777)
+			word >>= 12
+		}
+	}
+	return card
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"unicode"
+)
+
+// charset converts between host characters and device character codes
+type charset interface {
+	// decode returns host character for code, false for non printable code
+	decode(code besmWord) (rune, bool)
+	// encode returns device codes for host character
+	encode(r rune) []besmWord
+}
+
+// asciiCharset is 8-bit ASCII code
+type asciiCharset struct{}
+
+func (asciiCharset) decode(code besmWord) (rune, bool) {
+	return rune(code & 0o377), true
+}
+
+func (asciiCharset) encode(r rune) []besmWord {
+	if r > 0o377 {
+		return nil
+	}
+	return []besmWord{besmWord(r)}
+}
+
+// gostCharset is GOST 10859 code used by BESM-6 printers and terminals
+type gostCharset struct{}
+
+var gostChars = []rune("0123456789+-/,. ⏨↑()×=;[]*‘’≠<>:" +
+	"АБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЫЬЭЮЯ" +
+	"DFGIJLNQRSUVWZ¯≤≥∨∧⊃¬÷≡%◇|―_!\"Ъ°′")
+
+// line feed control code
+const gostNewline = 0o176
+
+// latin letters looking like cyrillic ones
+var gostLatin = map[rune]rune{
+	'A': 'А', 'B': 'В', 'C': 'С', 'E': 'Е', 'H': 'Н', 'K': 'К', 'M': 'М',
+	'O': 'О', 'P': 'Р', 'T': 'Т', 'X': 'Х', 'Y': 'У',
+}
+
+func (gostCharset) decode(code besmWord) (rune, bool) {
+	code &= 0o177
+	if code == gostNewline {
+		return '\n', true
+	}
+	if int(code) < len(gostChars) {
+		return gostChars[code], true
+	}
+	return 0, false
+}
+
+func (gostCharset) encode(r rune) []besmWord {
+	r = unicode.ToUpper(r)
+	if c, ok := gostLatin[r]; ok {
+		r = c
+	}
+	if r == '\n' {
+		return []besmWord{gostNewline}
+	}
+	for code, c := range gostChars {
+		if c == r {
+			return []besmWord{besmWord(code)}
+		}
+	}
+	return nil
+}
+
+// ita2Charset is 5-bit teleprinter code with letters and figures shifts
+type ita2Charset struct {
+	figsIn  bool // figures shift of decoded codes
+	figsOut bool // figures shift of encoded codes
+}
+
+const (
+	ita2Figs = 0o33
+	ita2Ltrs = 0o37
+)
+
+var ita2Letters = []rune("\x00E\nA SIU\rDRJNFCKTZLWHYPQOBG\x00MXV\x00")
+var ita2Figures = []rune("\x003\n- '87\r$4\a,!:(5+)2#6019?&\x00./=\x00")
+
+func (cs *ita2Charset) decode(code besmWord) (rune, bool) {
+	code &= 0o37
+	switch code {
+	case ita2Figs:
+		cs.figsIn = true
+		return 0, false
+	case ita2Ltrs:
+		cs.figsIn = false
+		return 0, false
+	case 0:
+		return 0, false
+	}
+	if cs.figsIn {
+		return ita2Figures[code], true
+	}
+	return ita2Letters[code], true
+}
+
+func (cs *ita2Charset) encode(r rune) []besmWord {
+	r = unicode.ToUpper(r)
+	if r == 0 {
+		return nil
+	}
+	// space, CR and LF are the same in both shifts
+	for code, c := range ita2Letters {
+		if c == r && (!cs.figsOut || ita2Figures[code] == r) {
+			return []besmWord{besmWord(code)}
+		}
+	}
+	for code, c := range ita2Figures {
+		if c == r && cs.figsOut {
+			return []besmWord{besmWord(code)}
+		}
+	}
+	for code, c := range ita2Letters {
+		if c == r {
+			cs.figsOut = false
+			return []besmWord{ita2Ltrs, besmWord(code)}
+		}
+	}
+	for code, c := range ita2Figures {
+		if c == r {
+			cs.figsOut = true
+			return []besmWord{ita2Figs, besmWord(code)}
+		}
+	}
+	return nil
+}
+
+// Console registers
+const (
+	conData    = iota // write outputs character, read inputs character
+	conStatus         // device status bits
+	conControl        // interrupt enable bits
+	conSize
+)
+
+// Console status and control bits
+const (
+	conOutReady = 1 // output is ready (status), interrupt on output (control)
+	conInReady  = 2 // input is ready (status), interrupt on input (control)
+)
+
+// Console is memory mapped teletype
+type Console struct {
+	name    string
+	in      *bufio.Reader // host input, nil without input or after its end
+	out     io.Writer
+	cs      charset
+	input   []besmWord // received character codes
+	delay   uint64     // instructions until next host character is received
+	control besmWord
+	IntLine
+}
+
+// instructions between received host characters, next character arrives
+// at fixed simulated time after previous one is read
+const conDelay = 100
+
+func newConsole(name string, in io.Reader, out io.Writer, cs charset) Console {
+	con := Console{name: name, out: out, cs: cs}
+	if in != nil {
+		con.in = bufio.NewReader(in)
+	}
+	return con
+}
+
+func (con *Console) raise(cond besmWord) {
+	if con.control&cond != 0 {
+		con.request()
+	}
+}
+
+func (con *Console) reset() {
+	con.input = nil
+	con.delay = 0
+	con.control = 0
+	con.deassert()
+}
+
+func (con *Console) getName() string {
+	return con.name
+}
+
+func (con *Console) getSize() uint16 {
+	return conSize
+}
+
+// poll receives next host character if there is no pending input and
+// line delay is over, reading host input stops simulation until host
+// character is typed
+func (con *Console) poll() {
+	for len(con.input) == 0 && con.delay == 0 && con.in != nil {
+		r, _, err := con.in.ReadRune()
+		if err != nil {
+			con.in = nil
+			break
+		}
+		con.input = con.cs.encode(r)
+	}
+	con.update()
+}
+
+// update holds input interrupt request while input is ready
+func (con *Console) update() {
+	if con.control&conInReady != 0 && len(con.input) != 0 {
+		con.assert()
+	} else {
+		con.deassert()
+	}
+}
+
+// tick counts down line delay and receives host input to request input
+// interrupt without program polling status
+func (con *Console) tick(n uint64) {
+	if con.delay > n {
+		con.delay -= n
+	} else {
+		con.delay = 0
+	}
+	if con.control&conInReady != 0 {
+		con.poll()
+	}
+}
+
+func (con *Console) read(addr uint16) besmWord {
+	switch addr {
+	case conData:
+		con.poll()
+		if len(con.input) == 0 {
+			return 0
+		}
+		code := con.input[0]
+		con.input = con.input[1:]
+		if len(con.input) == 0 {
+			con.delay = conDelay
+		}
+		con.update()
+		return code
+	case conStatus:
+		con.poll()
+		status := besmWord(conOutReady)
+		if len(con.input) != 0 {
+			status |= conInReady
+		}
+		return status
+	case conControl:
+		return con.control
+	}
+	return 0
+}
+
+func (con *Console) write(addr uint16, value besmWord) {
+	switch addr {
+	case conData:
+		if r, ok := con.cs.decode(value); ok && con.out != nil {
+			io.WriteString(con.out, string(r))
+		}
+		con.raise(conOutReady)
+	case conControl:
+		con.control = value & (conOutReady | conInReady)
+		con.update()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"math/bits"
 )
 
 // CPU state
@@ -30,7 +31,34 @@ type CPU struct {
 	cReg    uint16
 	vAddr   uint16 // ir_addr + c_mod  if set c_active (OpUTC OpWTC)
 
-	rrReg uint16 // machine mode and flag register
+	rrReg    uint16 // machine mode and flag register
+	rrCompat bool   // XTR and RTE use low 6 bits of word instead of exponent
+
+	user      bool   // user mode: privileged instructions are not allowed
+	userLo    uint16 // low address of user mode writable region
+	userHi    uint16 // high address of user mode writable region
+	mmu       *MMU   // user mode address translation
+	faultTrap bool   // raise interrupt on machine fault instead of stop
+	lastFault *Fault // last machine fault record
+	aborted   bool   // current instruction is aborted by fault to be restarted
+
+	intc     *IntController // interrupt requests from devices
+	intBase  uint16         // interrupt vector table address
+	intPC    uint16         // PC to return from interrupt (OpIJ)
+	intRight bool           // right instruction flag to return from interrupt
+	intRR    uint16         // RR register to restore on return from interrupt
+	intUser  bool           // user mode to restore on return from interrupt
+
+	extracodes  map[uint16]extracodeHandler // host side extracode handlers
+	extTrap     bool                        // trap extracodes without handler to vector table
+	extBase     uint16                      // extracode vector table address
+	inExtracode bool                        // extracode trap is being served
+	extSave     uint16                      // address of extracode return word (OpIJ 0(2))
+
+	steps    uint64     // executed instructions counter
+	channels []*Channel // block transfer channels running with CPU
+	stolen   uint64     // memory cycles stolen by channels
+	cycles   uint64     // simulated machine cycles, including stolen ones
 }
 
 func (cpu *CPU) reset() {
@@ -39,6 +67,19 @@ func (cpu *CPU) reset() {
 	cpu.M = [16]uint16{0}
 	cpu.right = false
 	cpu.Running = false
+	cpu.inExtracode = false
+	cpu.user = false
+	cpu.rrReg &^= 0b1000000
+	// aborted transfers request completion interrupts, drop them too
+	for _, ch := range cpu.channels {
+		ch.reset()
+	}
+	cpu.intc.reset()
+	cpu.mmu.reset()
+	cpu.lastFault = nil
+	cpu.steps = 0
+	cpu.stolen = 0
+	cpu.cycles = 0
 }
 
 func (cpu *CPU) setRLog() {
@@ -61,28 +102,90 @@ func (cpu *CPU) uAddr() uint16 {
 	return (cpu.M[cpu.irIND] + cpu.vAddr) & MASK15
 }
 
+// translate maps user mode virtual address to bus address with MMU
+func (cpu *CPU) translate(addr uint16) (uint16, bool) {
+	if cpu.user && cpu.mmu.enabled {
+		return cpu.mmu.translate(addr)
+	}
+	return addr, true
+}
+
+// fetch reads instruction word from instruction bus, returns false on fault
+func (cpu *CPU) fetch(addr uint16) (besmWord, bool) {
+	paddr, ok := cpu.translate(addr)
+	if !ok {
+		cpu.raiseFault(&Fault{faultPage, addr, false, 0, addr}, cpu.faultTrap)
+		return 0, false
+	}
+	if cpu.ibus.policy == busGarbage {
+		return cpu.ibus.read(paddr), true
+	}
+	word, ok := cpu.ibus.tryRead(paddr)
+	if !ok {
+		cpu.raiseFault(&Fault{faultBus, addr, false, 0, paddr}, cpu.ibus.policy == busInterrupt)
+	}
+	return word, ok
+}
+
+// load reads word from data bus
+func (cpu *CPU) load(addr uint16) besmWord {
+	paddr, ok := cpu.translate(addr)
+	if !ok {
+		cpu.abort(faultPage, addr)
+		return 0
+	}
+	if cpu.dbus.policy == busGarbage {
+		return cpu.dbus.read(paddr)
+	}
+	val, ok := cpu.dbus.tryRead(paddr)
+	if !ok {
+		cpu.busFault(cpu.dbus, paddr)
+	}
+	return val
+}
+
+// store writes word to data bus, in user mode only allowed range is writable
+func (cpu *CPU) store(addr uint16, value besmWord) {
+	if cpu.user && (addr < cpu.userLo || addr > cpu.userHi) {
+		cpu.abort(faultProtection, addr)
+		return
+	}
+	paddr, ok := cpu.translate(addr)
+	if !ok {
+		cpu.abort(faultPage, addr)
+		return
+	}
+	if cpu.dbus.policy == busGarbage {
+		cpu.dbus.write(paddr, value)
+	} else if !cpu.dbus.tryWrite(paddr, value) {
+		cpu.busFault(cpu.dbus, paddr)
+	}
+}
+
 func (cpu *CPU) atx() {
-	cpu.dbus.write(cpu.uAddr(), cpu.Acc)
+	cpu.store(cpu.uAddr(), cpu.Acc)
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] + 1) & MASK15
 	}
 }
 
 func (cpu *CPU) stx() {
-	cpu.dbus.write(cpu.uAddr(), cpu.Acc)
+	cpu.store(cpu.uAddr(), cpu.Acc)
 	cpu.M[15] = (cpu.M[15] - 1) & MASK15
-	cpu.Acc = cpu.dbus.read(cpu.M[15])
+	cpu.Acc = cpu.load(cpu.M[15])
 	cpu.setRLog()
 }
 
 func (cpu *CPU) xts() {
-	cpu.dbus.write(cpu.M[15], cpu.Acc)
+	cpu.store(cpu.M[15], cpu.Acc)
 	cpu.M[15] = (cpu.M[15] + 1) & MASK15
-	cpu.Acc = cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = cpu.load(cpu.uAddr())
 	cpu.setRLog()
 }
 
-func negate(val besmWord) besmWord {
+// negate changes sign of number, overflow is true if exponent of negated
+// number does not fit
+func negate(val besmWord) (besmWord, bool) {
 	// unpack number
 	exponent := val >> 41 & MASK7
 	mantissa := val & MASK41
@@ -95,9 +198,19 @@ func negate(val besmWord) besmWord {
 	// if bits 41 and 42 differs, then shift mantissa 1 bit right
 	if ((mantissa>>1)^mantissa)&BIT41 != 0 {
 		mantissa = mantissa >> 1
-		exponent = (exponent + 1) & MASK7
+		exponent++
 	}
-	return (exponent << 41) + (mantissa & MASK41)
+	return ((exponent & MASK7) << 41) + (mantissa & MASK41), exponent > MASK7
+}
+
+// negate changes sign of operand, raises overflow fault and returns false
+// if negated operand does not fit
+func (cpu *CPU) negate(val besmWord) (besmWord, bool) {
+	val, overflow := negate(val)
+	if overflow {
+		cpu.fault(faultOverflow, cpu.uAddr())
+	}
+	return val, !overflow
 }
 
 func printBesmNumber(val besmWord) string {
@@ -111,8 +224,6 @@ func printBesmNumber(val besmWord) string {
 }
 
 func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
-	doRound := cpu.rrReg&2 == 0
-	doNorm := cpu.rrReg&1 == 0
 	// 1. unpack
 	aExp := a >> 41 & MASK7
 	bExp := b >> 41 & MASK7
@@ -136,6 +247,7 @@ func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
 	// 3. align
 	sticky := false
 	for aExp != bExp {
+		cpu.cycles += shiftCycles
 		cpu.Rmr = cpu.Rmr >> 1
 		if bMant&1 != 0 {
 			cpu.Rmr = cpu.Rmr | BIT40
@@ -152,6 +264,15 @@ func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
 	}
 	// 4.add
 	aMant = (aMant + bMant) & MASK42
+	cpu.normalize(aExp, aMant, sticky)
+}
+
+// normalize performs normalization and rounding of sign-extended 42-bit
+// mantissa according to RR register flags and packs result to ACC.
+// Low order bits of mantissa are expected in low 40 bits of RMR.
+func (cpu *CPU) normalize(aExp besmWord, aMant besmWord, sticky bool) {
+	doRound := cpu.rrReg&2 == 0
+	doNorm := cpu.rrReg&1 == 0
 	rounded := false
 	// 5. normalization rounds
 	done := false
@@ -165,6 +286,7 @@ func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
 			break
 		} else if (aMant&BIT42 != 0) != (aMant&BIT41 != 0) {
 			// shift mantissa to right
+			cpu.cycles += shiftCycles
 			if aMant&1 != 0 {
 				sticky = true
 				cpu.Rmr = (cpu.Rmr >> 1) | BIT40
@@ -183,6 +305,7 @@ func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
 			}
 			break
 		} else if doNorm && ((aMant&BIT41 != 0) == (aMant&BIT40 != 0)) {
+			cpu.cycles += shiftCycles
 			aMant = (aMant << 1) & MASK42
 			if cpu.Rmr&BIT40 != 0 {
 				rounded = true
@@ -206,7 +329,9 @@ func (cpu *CPU) addsubrsub(a besmWord, b besmWord) {
 	}
 	// 7. pack
 	cpu.Acc = ((aExp & MASK7) << 41) | (aMant & MASK41)
-
+	if aExp > MASK7 {
+		cpu.fault(faultOverflow, cpu.uAddr())
+	}
 }
 
 func (cpu *CPU) add() {
@@ -215,7 +340,7 @@ func (cpu *CPU) add() {
 	}
 
 	a := cpu.Acc
-	b := cpu.dbus.read(cpu.uAddr())
+	b := cpu.load(cpu.uAddr())
 	cpu.addsubrsub(a, b)
 
 	//log.Println("ADD", printBesmNumber(a), printBesmNumber(b), "=", printBesmNumber(cpu.Acc))
@@ -229,9 +354,13 @@ func (cpu *CPU) sub() {
 	}
 
 	a := cpu.Acc
-	b := cpu.dbus.read(cpu.uAddr())
+	b := cpu.load(cpu.uAddr())
 
-	cpu.addsubrsub(a, negate(b))
+	b, ok := cpu.negate(b)
+	if !ok {
+		return
+	}
+	cpu.addsubrsub(a, b)
 
 	//log.Println("SUB", printBesmNumber(a), printBesmNumber(b), "=", printBesmNumber(cpu.Acc))
 
@@ -244,20 +373,205 @@ func (cpu *CPU) rsub() {
 	}
 
 	a := cpu.Acc
-	b := cpu.dbus.read(cpu.uAddr())
+	b := cpu.load(cpu.uAddr())
 
-	cpu.addsubrsub(negate(a), b)
+	a, ok := cpu.negate(a)
+	if !ok {
+		return
+	}
+	cpu.addsubrsub(a, b)
 	//log.Println("RSUB", printBesmNumber(a), printBesmNumber(b), "=", printBesmNumber(cpu.Acc))
 
 	cpu.setRAdd()
 }
 
+func (cpu *CPU) amx() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+
+	a := cpu.Acc
+	b := cpu.load(cpu.uAddr())
+	// |a| - |b|
+	ok := true
+	if a&BIT41 != 0 {
+		a, ok = cpu.negate(a)
+	}
+	if ok && b&BIT41 == 0 {
+		b, ok = cpu.negate(b)
+	}
+	if !ok {
+		return
+	}
+	cpu.addsubrsub(a, b)
+
+	cpu.setRAdd()
+}
+
+func (cpu *CPU) avx() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+
+	a := cpu.Acc
+	// change sign of ACC if operand is negative
+	if cpu.load(cpu.uAddr())&BIT41 != 0 {
+		var ok bool
+		if a, ok = cpu.negate(a); !ok {
+			return
+		}
+	}
+	aExp, aMant := unpack(a)
+	// reset low 40 bits
+	cpu.Rmr = cpu.Rmr & 0o7760000000000000
+	cpu.normalize(aExp, aMant, false)
+
+	cpu.setRAdd()
+}
+
+// unpack splits number to exponent and sign-extended 42-bit mantissa
+func unpack(val besmWord) (exponent besmWord, mantissa besmWord) {
+	exponent = val >> 41 & MASK7
+	mantissa = val & MASK41
+	if mantissa&BIT41 != 0 {
+		mantissa |= BIT42
+	}
+	return exponent, mantissa
+}
+
+// unpackAbs splits number to exponent, absolute value of mantissa and sign
+func unpackAbs(val besmWord) (exponent besmWord, mantissa besmWord, neg bool) {
+	exponent = val >> 41 & MASK7
+	mantissa = val & MASK41
+	if mantissa&BIT41 != 0 {
+		mantissa = (mantissa ^ MASK41) + 1
+		neg = true
+	}
+	return exponent, mantissa, neg
+}
+
+// negateDouble makes two's complement of double length mantissa:
+// sign-extended 42-bit high part and 40-bit low part
+func negateDouble(hi besmWord, lo besmWord) (besmWord, besmWord) {
+	lo = (lo ^ MASK40) + 1
+	hi = (hi ^ MASK42) + (lo >> 40)
+	return hi & MASK42, lo & MASK40
+}
+
+func (cpu *CPU) mul() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+
+	aExp, aMant, aNeg := unpackAbs(cpu.Acc)
+	bExp, bMant, bNeg := unpackAbs(cpu.load(cpu.uAddr()))
+	// reset low 40 bits
+	cpu.Rmr = cpu.Rmr & 0o7760000000000000
+	if aMant == 0 || bMant == 0 {
+		cpu.Acc = 0
+		cpu.setRMul()
+		return
+	}
+	// 80-bit product: high 40 bits to ACC, low 40 bits to RMR
+	hi, lo := bits.Mul64(uint64(aMant), uint64(bMant))
+	mant := besmWord(hi<<24 | lo>>40)
+	low := besmWord(lo) & MASK40
+	if aNeg != bNeg {
+		mant, low = negateDouble(mant, low)
+	}
+	cpu.Rmr |= low
+	cpu.normalize(aExp+bExp-64, mant, false)
+
+	cpu.setRMul()
+}
+
+func (cpu *CPU) div() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+
+	aExp, aMant, aNeg := unpackAbs(cpu.Acc)
+	bExp, bMant, bNeg := unpackAbs(cpu.load(cpu.uAddr()))
+	if bMant == 0 {
+		cpu.fault(faultDivZero, cpu.uAddr())
+		return
+	}
+	// reset low 40 bits
+	cpu.Rmr = cpu.Rmr & 0o7760000000000000
+	if aMant == 0 {
+		cpu.Acc = 0
+		cpu.setRMul()
+		return
+	}
+	// bring both mantissas to [1/2, 1) so quotient fits to 41 bits
+	for aMant&BIT41 != 0 {
+		aMant = aMant >> 1
+		aExp++
+	}
+	for bMant&BIT41 != 0 {
+		bMant = bMant >> 1
+		bExp++
+	}
+	for bMant&BIT40 == 0 {
+		bMant = bMant << 1
+		bExp--
+	}
+	// quotient high part goes to ACC, next 40 bits to RMR
+	q, r := bits.Div64(uint64(aMant>>24), uint64(aMant)<<40, uint64(bMant))
+	qLow, r := bits.Div64(r>>24, r<<40, uint64(bMant))
+	mant := besmWord(q)
+	low := besmWord(qLow)
+	if aNeg != bNeg {
+		mant, low = negateDouble(mant, low)
+	}
+	cpu.Rmr |= low
+	cpu.normalize(aExp-bExp+64, mant, r != 0)
+
+	cpu.setRMul()
+}
+
+// addExp adds (or subtracts if sub is set) n-64 to exponent of ACC
+func (cpu *CPU) addExp(n besmWord, sub bool) {
+	aExp, aMant := unpack(cpu.Acc)
+	if sub {
+		aExp = aExp - n + 64
+	} else {
+		aExp = aExp + n - 64
+	}
+	// reset low 40 bits
+	cpu.Rmr = cpu.Rmr & 0o7760000000000000
+	cpu.normalize(aExp, aMant, false)
+	cpu.setRMul()
+}
+
+func (cpu *CPU) eaddx() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+	cpu.addExp(cpu.load(cpu.uAddr())>>41&MASK7, false)
+}
+
+func (cpu *CPU) esubx() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+	cpu.addExp(cpu.load(cpu.uAddr())>>41&MASK7, true)
+}
+
+func (cpu *CPU) eaddn() {
+	cpu.addExp(besmWord(cpu.uAddr()&MASK7), false)
+}
+
+func (cpu *CPU) esub() {
+	cpu.addExp(besmWord(cpu.uAddr()&MASK7), true)
+}
+
 func (cpu *CPU) yta() {
 	if cpu.isRLog() {
 		cpu.Acc = cpu.Rmr
 	} else {
 		aMant := cpu.Rmr & MASK40
-		aExp := ((cpu.Acc >> 41 & MASK7) + besmWord(cpu.uAddr()&MASK7) - 64) & MASK7
+		aExp := (cpu.Acc >> 41 & MASK7) + besmWord(cpu.uAddr()&MASK7) - 64
 		doNorm := cpu.rrReg&1 == 0
 
 		if doNorm {
@@ -270,6 +584,7 @@ func (cpu *CPU) yta() {
 					break
 				} else if (aMant&BIT42 != 0) != (aMant&BIT41 != 0) {
 					// shift mantissa to right
+					cpu.cycles += shiftCycles
 					if aMant&1 != 0 {
 						cpu.Rmr = (cpu.Rmr >> 1) | BIT40
 					} else {
@@ -284,6 +599,7 @@ func (cpu *CPU) yta() {
 					aExp++
 					break
 				} else if doNorm && ((aMant&BIT41 != 0) == (aMant&BIT40 != 0)) {
+					cpu.cycles += shiftCycles
 					aMant = (aMant << 1) & MASK41
 					if cpu.Rmr&BIT40 != 0 {
 						aMant = aMant | 1
@@ -296,6 +612,9 @@ func (cpu *CPU) yta() {
 			}
 		}
 		cpu.Acc = ((aExp & MASK7) << 41) | (aMant & MASK41)
+		if aExp&0x100 == 0 && aExp > MASK7 {
+			cpu.fault(faultOverflow, cpu.uAddr())
+		}
 	}
 }
 
@@ -303,7 +622,7 @@ func (cpu *CPU) aax() {
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
-	cpu.Acc = cpu.Acc & cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = cpu.Acc & cpu.load(cpu.uAddr())
 	cpu.Rmr = 0
 	cpu.setRLog()
 }
@@ -313,7 +632,7 @@ func (cpu *CPU) aex() {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
 	cpu.Rmr = cpu.Acc
-	cpu.Acc = cpu.Acc ^ cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = cpu.Acc ^ cpu.load(cpu.uAddr())
 	cpu.setRLog()
 }
 
@@ -321,7 +640,7 @@ func (cpu *CPU) aox() {
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
-	cpu.Acc = cpu.Acc | cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = cpu.Acc | cpu.load(cpu.uAddr())
 	cpu.Rmr = 0
 	cpu.setRLog()
 }
@@ -335,6 +654,7 @@ func (cpu *CPU) asn() {
 	if bExp >= 64 {
 		// shift right
 		for bExp != 64 {
+			cpu.cycles += shiftCycles
 			cpu.Rmr = cpu.Rmr >> 1
 			if cpu.Acc&1 != 0 {
 				cpu.Rmr |= BIT48
@@ -345,6 +665,7 @@ func (cpu *CPU) asn() {
 	} else {
 		// shift left
 		for bExp != 64 {
+			cpu.cycles += shiftCycles
 			cpu.Rmr = cpu.Rmr << 1
 			if cpu.Acc&BIT48 != 0 {
 				cpu.Rmr |= 1
@@ -361,10 +682,11 @@ func (cpu *CPU) asx() {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
 	cpu.Rmr = besmWord(0)
-	bExp := (cpu.dbus.read(cpu.uAddr()) >> 41) & MASK7
+	bExp := (cpu.load(cpu.uAddr()) >> 41) & MASK7
 	if bExp >= 64 {
 		// shift right
 		for bExp != 64 {
+			cpu.cycles += shiftCycles
 			cpu.Rmr = cpu.Rmr >> 1
 			if cpu.Acc&1 != 0 {
 				cpu.Rmr |= BIT48
@@ -375,6 +697,7 @@ func (cpu *CPU) asx() {
 	} else {
 		// shift left
 		for bExp != 64 {
+			cpu.cycles += shiftCycles
 			cpu.Rmr = cpu.Rmr << 1
 			if cpu.Acc&BIT48 != 0 {
 				cpu.Rmr |= 1
@@ -390,7 +713,7 @@ func (cpu *CPU) arx() {
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
-	cpu.Acc = cpu.dbus.read(cpu.uAddr()) + cpu.Acc
+	cpu.Acc = cpu.load(cpu.uAddr()) + cpu.Acc
 	if cpu.Acc&BIT49 != 0 {
 		cpu.Acc = (cpu.Acc + 1) & MASK48
 	}
@@ -402,7 +725,7 @@ func (cpu *CPU) apx() {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
 	t := besmWord(0)
-	cpu.Rmr = cpu.dbus.read(cpu.uAddr())
+	cpu.Rmr = cpu.load(cpu.uAddr())
 	bit := 47
 	for cpu.Rmr != 0 {
 		if cpu.Rmr&BIT48 != 0 {
@@ -423,7 +746,7 @@ func (cpu *CPU) aux() {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
 	t := besmWord(0)
-	cpu.Rmr = cpu.dbus.read(cpu.uAddr())
+	cpu.Rmr = cpu.load(cpu.uAddr())
 	bit := 47
 	for cpu.Rmr != 0 {
 		if cpu.Rmr&BIT48 != 0 {
@@ -452,7 +775,7 @@ func (cpu *CPU) acx() {
 		}
 		cpu.Acc = cpu.Acc >> 1
 	}
-	cpu.Acc = t + cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = t + cpu.load(cpu.uAddr())
 	if cpu.Acc&BIT49 != 0 {
 		cpu.Acc = (cpu.Acc + 1) & MASK48
 	}
@@ -465,14 +788,14 @@ func (cpu *CPU) anx() {
 	}
 	if cpu.Acc == 0 {
 		cpu.Rmr = 0
-		cpu.Acc = cpu.dbus.read(cpu.uAddr())
+		cpu.Acc = cpu.load(cpu.uAddr())
 	} else {
 		bit := besmWord(1)
 		for t := cpu.Acc; t != 0 && t&BIT48 == 0; t = t << 1 {
 			bit++
 		}
 		cpu.Rmr = cpu.Acc << bit
-		cpu.Acc = bit + cpu.dbus.read(cpu.uAddr())
+		cpu.Acc = bit + cpu.load(cpu.uAddr())
 		if cpu.Acc&BIT49 != 0 {
 			cpu.Acc = (cpu.Acc + 1) & MASK48
 		}
@@ -492,6 +815,36 @@ func (cpu *CPU) ati() {
 	}
 }
 
+func (cpu *CPU) sti() {
+	t := cpu.uAddr() & 0xF
+	addr := uint16(cpu.Acc & MASK15)
+	// pop ACC from stack, when M15 is target it is popped from new stack address
+	if t != 15 {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+		cpu.Acc = cpu.load(cpu.M[15])
+	} else {
+		cpu.Acc = cpu.load(addr)
+	}
+	if t != 0 {
+		cpu.M[t] = addr
+	}
+	cpu.setRLog()
+}
+
+func (cpu *CPU) its() {
+	cpu.store(cpu.M[15], cpu.Acc)
+	cpu.M[15] = (cpu.M[15] + 1) & MASK15
+	cpu.Acc = besmWord(cpu.M[cpu.uAddr()&0xF])
+	cpu.setRLog()
+}
+
+func (cpu *CPU) mtj() {
+	t := cpu.vAddr & 0xF
+	if t != 0 {
+		cpu.M[t] = cpu.M[cpu.irIND]
+	}
+}
+
 func (cpu *CPU) jaddm() {
 	t := cpu.vAddr & 0xF
 	if t != 0 {
@@ -521,6 +874,80 @@ func (cpu *CPU) vim() {
 	}
 }
 
+func (cpu *CPU) vzm() {
+	if cpu.M[cpu.irIND] == 0 {
+		cpu.pcNext = cpu.vAddr
+		cpu.right = false
+	}
+}
+
+func (cpu *CPU) vlm() {
+	// loop while M register is not zero
+	if cpu.M[cpu.irIND] != 0 {
+		cpu.M[cpu.irIND] = (cpu.M[cpu.irIND] + 1) & MASK15
+		cpu.pcNext = cpu.vAddr
+		cpu.right = false
+	}
+}
+
+// jumpTo transfers control to left or right instruction at addr
+func (cpu *CPU) jumpTo(addr uint16, right bool) {
+	cpu.pcNext = addr
+	cpu.right = right
+	if right {
+		// refill instruction cache to continue from right instruction
+		cpu.irCache, _ = cpu.fetch(addr)
+	}
+}
+
+func (cpu *CPU) ij() {
+	// IJ addr(1) enters user mode at addr
+	if cpu.irIND == 1 {
+		cpu.user = true
+		cpu.jumpTo(cpu.vAddr, false)
+		return
+	}
+	// IJ 0(2) returns from extracode
+	if cpu.irIND == 2 {
+		if !cpu.inExtracode {
+			log.Printf("IJ outside of extracode at %05o", cpu.PC)
+			cpu.Running = false
+			return
+		}
+		ret := cpu.load(cpu.extSave)
+		cpu.inExtracode = false
+		cpu.user = ret&extRetUser != 0
+		cpu.jumpTo(uint16(ret&MASK15), ret&extRetRight != 0)
+		return
+	}
+	// bit 6 of RR register is "in interrupt" flag
+	if cpu.rrReg&0b1000000 == 0 {
+		log.Printf("IJ outside of interrupt at %05o", cpu.PC)
+		cpu.Running = false
+		return
+	}
+	cpu.rrReg = cpu.intRR &^ 0b1000000
+	cpu.user = cpu.intUser
+	cpu.jumpTo(cpu.intPC, cpu.intRight)
+}
+
+// interrupt saves execution state, sets "in interrupt" flag and
+// continues from interrupt vector of line in supervisor mode.
+// Handler returns with IJ.
+func (cpu *CPU) interrupt(line int) {
+	if cpu.trace {
+		fmt.Println("==== INTERRUPT", line, "====")
+	}
+	cpu.intPC = cpu.PC
+	cpu.intRight = cpu.right
+	cpu.intRR = cpu.rrReg
+	cpu.intUser = cpu.user
+	cpu.user = false
+	cpu.rrReg |= 0b1000000
+	cpu.PC = (cpu.intBase + uint16(line)) & MASK15
+	cpu.right = false
+}
+
 func (cpu *CPU) uj() {
 	cpu.pcNext = cpu.uAddr()
 	cpu.right = false
@@ -530,14 +957,33 @@ func (cpu *CPU) xtr() {
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
-	// TODO: in real MESM6 r[5:0] = dbus[46:41]
-	// TODO: bin r[6] is "in interrupt" flag - ignoring
-	cpu.rrReg = cpu.rrReg&0b1000000 | uint16(cpu.dbus.read(cpu.uAddr())&0o77)
+	val := cpu.load(cpu.uAddr())
+	if !cpu.rrCompat {
+		// in real MESM6 r[5:0] = dbus[46:41]
+		val = val >> 41
+	}
+	// bit 6 of RR register is "in interrupt" flag, so preserve it
+	cpu.rrReg = cpu.rrReg&0b1000000 | uint16(val&0o77)
 }
 
 func (cpu *CPU) rte() {
-	// TODO: in real MESM6 Acc[47:42] = r[5:0] (exponent = r)
-	cpu.Acc = besmWord(cpu.rrReg) & 0o77
+	if cpu.rrCompat {
+		cpu.Acc = besmWord(cpu.rrReg) & 0o77
+		return
+	}
+	// in real MESM6 exponent of ACC is set to RR masked by executive address
+	cpu.Acc = besmWord(cpu.rrReg&cpu.uAddr()&MASK7) << 41
+	cpu.setRLog()
+}
+
+func (cpu *CPU) readPage() {
+	cpu.Acc = besmWord(cpu.mmu.pages[cpu.uAddr()%pageCount])
+	cpu.setRLog()
+}
+
+func (cpu *CPU) writePage() {
+	// page register is loaded with physical page and valid bit from ACC
+	cpu.mmu.pages[cpu.uAddr()%pageCount] = uint16(cpu.Acc) & (pageValid | (pageCount - 1))
 }
 
 func (cpu *CPU) ntr() {
@@ -550,7 +996,7 @@ func (cpu *CPU) xta() {
 	if cpu.stack {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
-	cpu.Acc = cpu.dbus.read(cpu.uAddr())
+	cpu.Acc = cpu.load(cpu.uAddr())
 	cpu.setRLog()
 }
 
@@ -593,6 +1039,7 @@ func (cpu *CPU) stop() {
 		log.Println("FAILED STOP")
 	}
 	cpu.Running = false
+	log.Println(cpu.timing())
 }
 
 func (cpu *CPU) vtm() {
@@ -601,6 +1048,14 @@ func (cpu *CPU) vtm() {
 	}
 }
 
+func (cpu *CPU) mod() {
+	if cpu.stack {
+		cpu.M[15] = (cpu.M[15] - 1) & MASK15
+	}
+	cpu.cActive = true
+	cpu.cReg = uint16(cpu.load(cpu.uAddr()) & MASK15)
+}
+
 func (cpu *CPU) utc() {
 	cpu.cActive = true
 	cpu.cReg = cpu.uAddr()
@@ -611,17 +1066,34 @@ func (cpu *CPU) wtc() {
 		cpu.M[15] = (cpu.M[15] - 1) & MASK15
 	}
 	cpu.cActive = true
-	cpu.cReg = uint16(cpu.dbus.read(cpu.uAddr()))
+	cpu.cReg = uint16(cpu.load(cpu.uAddr()))
 }
 
 func (cpu *CPU) step() {
+	// serve interrupt requests, but do not split address modification
+	// (OpUTC, OpWTC) from modified instruction
+	if cpu.rrReg&0b1000000 == 0 && !cpu.cActive {
+		if line, ok := cpu.intc.acknowledge(); ok {
+			cpu.cycles += interruptCycles
+			cpu.interrupt(line)
+		}
+	}
 	// FETCH instruction from cache or
 	cpu.ir = cpu.irCache & MASK24
 	cpu.pcNext = cpu.PC
+	cpu.aborted = false
 	// if last step was executed right instruction
 	if !cpu.right {
 		// fetch new instruction from insruction bus
-		cpu.irCache = cpu.ibus.read(cpu.PC)
+		cpu.cycles += fetchCycles
+		word, ok := cpu.fetch(cpu.PC)
+		if !ok {
+			// instruction is fetched again after fault
+			cpu.steps++
+			cpu.endStep()
+			return
+		}
+		cpu.irCache = word
 		cpu.ir = cpu.irCache >> 24
 	} else {
 		cpu.pcNext = (cpu.PC + 1) & MASK15
@@ -632,6 +1104,11 @@ func (cpu *CPU) step() {
 		cpu.state()
 		fmt.Printf("\nAfter execution of: %s\n", decodeOp(cpu.ir))
 	}
+	// registers changed by instruction are restored if it is aborted by
+	// fault, so it is executed again after return from fault handler
+	right, irCache, cActive, cReg := cpu.right, cpu.irCache, cpu.cActive, cpu.cReg
+	acc, rmr, m, rr := cpu.Acc, cpu.Rmr, cpu.M, cpu.rrReg
+	user, inExtracode := cpu.user, cpu.inExtracode
 	cpu.right = !cpu.right
 	// DECODE step 1. unpack instruction
 	cpu.irIND = uint16((cpu.ir >> 20) & 0xF)
@@ -663,7 +1140,44 @@ func (cpu *CPU) step() {
 			}
 		}
 	}
+	cpu.steps++
+	cpu.cycles += opCycles[cpu.irOp]
 	// EXECUTE
+	if cpu.user && isPrivileged(cpu.irOp) {
+		cpu.fault(faultPrivileged, cpu.uAddr())
+	} else {
+		cpu.execute()
+	}
+	if cpu.aborted {
+		cpu.right, cpu.irCache, cpu.cActive, cpu.cReg = right, irCache, cActive, cReg
+		cpu.Acc, cpu.Rmr, cpu.M, cpu.rrReg = acc, rmr, m, rr
+		cpu.user, cpu.inExtracode = user, inExtracode
+		cpu.pcNext = cpu.PC
+	}
+	// advance instrunction pointer
+	cpu.PC = cpu.pcNext
+	cpu.endStep()
+}
+
+// endStep advances clocked devices and channels by executed instruction
+func (cpu *CPU) endStep() {
+	cpu.ibus.tick(1)
+	if cpu.dbus != cpu.ibus {
+		cpu.dbus.tick(1)
+	}
+	for _, ch := range cpu.channels {
+		stolen := ch.tick()
+		cpu.stolen += stolen
+		cpu.cycles += stolen
+	}
+
+	if cpu.trace {
+		cpu.state()
+		fmt.Println("=== END ===")
+	}
+}
+
+func (cpu *CPU) execute() {
 	switch cpu.irOp {
 	case OpATX:
 		cpu.atx()
@@ -679,8 +1193,24 @@ func (cpu *CPU) step() {
 		cpu.add()
 	case OpRSUB:
 		cpu.rsub()
+	case OpAMX:
+		cpu.amx()
+	case OpAVX:
+		cpu.avx()
+	case OpMUL:
+		cpu.mul()
+	case OpDIV:
+		cpu.div()
 	case OpYTA:
 		cpu.yta()
+	case OpEADDX:
+		cpu.eaddx()
+	case OpESUBX:
+		cpu.esubx()
+	case OpEADDN:
+		cpu.eaddn()
+	case OpESUB:
+		cpu.esub()
 	case OpAAX:
 		cpu.aax()
 	case OpAEX:
@@ -703,6 +1233,14 @@ func (cpu *CPU) step() {
 		cpu.anx()
 	case OpNTR:
 		cpu.ntr()
+	case OpE32:
+		cpu.readPage()
+	case OpE33:
+		cpu.writePage()
+	case OpXTR:
+		cpu.xtr()
+	case OpRTE:
+		cpu.rte()
 	case OpUIA:
 		cpu.uia()
 	case OpUZA:
@@ -715,6 +1253,12 @@ func (cpu *CPU) step() {
 		cpu.ita()
 	case OpATI:
 		cpu.ati()
+	case OpSTI:
+		cpu.sti()
+	case OpITS:
+		cpu.its()
+	case OpMTJ:
+		cpu.mtj()
 	case OpJADDM:
 		cpu.jaddm()
 	case OpUTM:
@@ -723,23 +1267,30 @@ func (cpu *CPU) step() {
 		cpu.vjm()
 	case OpVIM:
 		cpu.vim()
+	case OpVZM:
+		cpu.vzm()
+	case OpVLM:
+		cpu.vlm()
+	case OpIJ:
+		cpu.ij()
 	case OpUJ:
 		cpu.uj()
 	case OpUTC:
 		cpu.utc()
 	case OpWTC:
 		cpu.wtc()
+	case OpMOD:
+		cpu.mod()
+	case OpE20, OpE21, OpE36:
+		cpu.extracode(cpu.irOp >> 3)
+	case OpE50, OpE51, OpE52, OpE53, OpE54, OpE55, OpE56, OpE57,
+		OpE60, OpE61, OpE62, OpE63, OpE64, OpE65, OpE66, OpE67,
+		OpE70, OpE71, OpE72, OpE73, OpE74, OpE75, OpE76, OpE77:
+		cpu.extracode(cpu.irOp)
 	default:
 		log.Printf("Unimplemented opcode: %03o - %s", cpu.irOp, decodeOp(cpu.ir))
 		cpu.Running = false
 	}
-	// advance instrunction pointer
-	cpu.PC = cpu.pcNext
-
-	if cpu.trace {
-		cpu.state()
-		fmt.Println("=== END ===")
-	}
 }
 
 func (cpu *CPU) state() {
@@ -757,10 +1308,22 @@ func (cpu *CPU) run() {
 	}
 }
 
+// addChannel makes channel transfer words while CPU executes instructions
+func (cpu *CPU) addChannel(ch *Channel) {
+	ch.owned = true
+	cpu.channels = append(cpu.channels, ch)
+}
+
 func newCPU(ibus *Bus, dbus *Bus) *CPU {
 	cpu := CPU{}
 	cpu.ibus = ibus
 	cpu.dbus = dbus
+	cpu.extracodes = make(map[uint16]extracodeHandler)
+	cpu.extBase = 0o500
+	cpu.extSave = 0o477
+	cpu.intc = newIntController()
+	cpu.intBase = 0o600
+	cpu.mmu = newMMU()
 	cpu.reset()
 	return &cpu
 }
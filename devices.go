@@ -1,6 +1,11 @@
 package main
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
 
 // MemRegion of device attached to bus
 type MemRegion struct {
@@ -16,6 +21,18 @@ type Device interface {
 	write(addr uint16, value besmWord)
 }
 
+// clockedDevice is implemented by devices which evolve between CPU
+// accesses, tick is called with number of executed instructions
+type clockedDevice interface {
+	tick(n uint64)
+}
+
+// sizedDevice is implemented by devices which may be smaller than
+// attached memory region
+type sizedDevice interface {
+	getSize() uint16
+}
+
 // Memory holds read/write data
 type Memory struct {
 	name string
@@ -31,6 +48,10 @@ func (m *Memory) getName() string {
 	return m.name
 }
 
+func (m *Memory) getSize() uint16 {
+	return m.size
+}
+
 func (m *Memory) read(addr uint16) besmWord {
 	if addr < m.size {
 		return m.data[addr]
@@ -52,15 +73,93 @@ func newMemory(name string, size uint16) Memory {
 	return Memory{name, size, make([]besmWord, size)}
 }
 
+// romDevice is implemented by devices not writable by CPU, which
+// contents is set by host
+type romDevice interface {
+	program(addr uint16, value besmWord)
+}
+
+// ROM holds read only data
+type ROM struct {
+	Memory
+}
+
+// reset keeps contents of ROM
+func (r *ROM) reset() {
+}
+
+func (r *ROM) write(addr uint16, value besmWord) {
+	log.Printf("MEM: Write to read only %s at: 0o%o", r.name, addr)
+}
+
+// program sets ROM contents
+func (r *ROM) program(addr uint16, value besmWord) {
+	r.Memory.write(addr, value)
+}
+
+func newROM(name string, size uint16) ROM {
+	return ROM{newMemory(name, size)}
+}
+
+// Bus error policies on access to address without device
+const (
+	busGarbage   = iota // read returns garbage, write is ignored
+	busStop             // CPU stops with fault record
+	busInterrupt        // CPU raises fault interrupt
+)
+
+// Bus zero address modes
+const (
+	zeroRead  = iota // read of address 0 returns 0, write goes to device
+	zeroCell         // address 0 is ordinary cell of attached device
+	zeroConst        // addresses from 0 are hardwired constant register bank, write is ignored
+)
+
+// maximum number of devices attached to bus
+const busMaxDevices = 255
+
 // Bus is used by CPU to read/write mmaped devices
 type Bus struct {
 	name    string
 	mmaps   []MemRegion
 	devices []Device
+	sizes   []uint16        // number of words accessible in device region
+	decode  []uint8         // device index + 1 for every address, 0 - no device
+	policy  int             // bus error policy
+	clocked []clockedDevice // devices advanced as CPU executes
+
+	zeroMode int        // zero address mode
+	consts   []besmWord // constant register bank at addresses 0.. in zeroConst mode
 }
 
 func newBus(name string) *Bus {
-	return &Bus{name, nil, nil}
+	return &Bus{name: name, decode: make([]uint8, MASK15+1), policy: busGarbage, zeroMode: zeroRead}
+}
+
+// setConstants makes addresses from 0 hardwired constant register bank
+// with values, address 0 is constant 0 with empty bank
+func (bus *Bus) setConstants(values ...besmWord) {
+	bus.zeroMode = zeroConst
+	bus.consts = append([]besmWord(nil), values...)
+}
+
+// isConst reports whether address is in constant register bank
+func (bus *Bus) isConst(addr uint16) bool {
+	return bus.zeroMode == zeroConst && (addr == 0 || int(addr) < len(bus.consts))
+}
+
+// constant returns value of address which is not read from device
+func (bus *Bus) constant(addr uint16) (besmWord, bool) {
+	switch bus.zeroMode {
+	case zeroRead:
+		return 0, addr == 0
+	case zeroConst:
+		if int(addr) < len(bus.consts) {
+			return bus.consts[addr], true
+		}
+		return 0, addr == 0
+	}
+	return 0, false
 }
 
 func (bus *Bus) reset() {
@@ -69,36 +168,146 @@ func (bus *Bus) reset() {
 	}
 }
 
-func (bus *Bus) attach(memRegion MemRegion, dev Device) {
+// tick advances clocked devices attached to bus
+func (bus *Bus) tick(n uint64) {
+	for _, dev := range bus.clocked {
+		dev.tick(n)
+	}
+}
+
+func (bus *Bus) attach(memRegion MemRegion, dev Device) error {
+	if memRegion.start > memRegion.end || memRegion.end > MASK15 {
+		return fmt.Errorf("device %s has invalid region %05o-%05o", dev.getName(), memRegion.start, memRegion.end)
+	}
 	for i, mmap := range bus.mmaps {
-		if mmap.start <= memRegion.start && memRegion.end <= mmap.end {
-			log.Println("BUS: Device", dev.getName(), "clamps with", bus.devices[i].getName())
-			return
+		if memRegion.start <= mmap.end && mmap.start <= memRegion.end {
+			return fmt.Errorf("device %s region %05o-%05o overlaps %s region %05o-%05o on %s",
+				dev.getName(), memRegion.start, memRegion.end,
+				bus.devices[i].getName(), mmap.start, mmap.end, bus.name)
 		}
 	}
+	if len(bus.devices) == busMaxDevices {
+		return fmt.Errorf("too many devices on %s", bus.name)
+	}
+	size := memRegion.end - memRegion.start + 1
+	if sized, ok := dev.(sizedDevice); ok && sized.getSize() < size {
+		size = sized.getSize()
+	}
 	bus.mmaps = append(bus.mmaps, memRegion)
 	bus.devices = append(bus.devices, dev)
+	bus.sizes = append(bus.sizes, size)
+	if clocked, ok := dev.(clockedDevice); ok {
+		bus.clocked = append(bus.clocked, clocked)
+	}
+	for addr := int(memRegion.start); addr <= int(memRegion.end); addr++ {
+		bus.decode[addr] = uint8(len(bus.devices))
+	}
+	return nil
 }
 
-func (bus *Bus) read(addr uint16) besmWord {
-	if addr == 0 {
-		return 0
+// find returns index of device attached at address
+func (bus *Bus) find(addr uint16) (int, bool) {
+	if addr > MASK15 {
+		return 0, false
 	}
-	for i, mmap := range bus.mmaps {
-		if mmap.start <= addr && addr <= mmap.end {
-			return bus.devices[i].read(addr - mmap.start)
+	i := bus.decode[addr]
+	return int(i) - 1, i != 0
+}
+
+// String lists attached devices ordered by address
+func (bus *Bus) String() string {
+	order := make([]int, len(bus.mmaps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bus.mmaps[order[a]].start < bus.mmaps[order[b]].start
+	})
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s:\n", bus.name)
+	for _, i := range order {
+		fmt.Fprintf(&sb, "%05o-%05o %s\n", bus.mmaps[i].start, bus.mmaps[i].end, bus.devices[i].getName())
+	}
+	return sb.String()
+}
+
+func (bus *Bus) read(addr uint16) besmWord {
+	if addr == 0 || int(addr) < len(bus.consts) {
+		if val, ok := bus.constant(addr); ok {
+			return val
 		}
 	}
+	if i, ok := bus.find(addr); ok {
+		return bus.devices[i].read(addr - bus.mmaps[i].start)
+	}
 	log.Printf("BUS: %s read out of bounds: 0o%o", bus.name, addr)
 	return 0o7654123450517667 // garbage from unconnected bus
 }
 
 func (bus *Bus) write(addr uint16, value besmWord) {
-	for i, mmap := range bus.mmaps {
-		if mmap.start <= addr && addr <= mmap.end {
-			bus.devices[i].write(addr-mmap.start, value)
-			return
-		}
+	if bus.isConst(addr) {
+		return
+	}
+	if i, ok := bus.find(addr); ok {
+		bus.devices[i].write(addr-bus.mmaps[i].start, value)
+		return
 	}
 	log.Printf("BUS: %s write out of device address space,  0o%o", bus.name, addr)
 }
+
+// lookup finds device and its local address for bus address
+func (bus *Bus) lookup(addr uint16) (Device, uint16, bool) {
+	i, ok := bus.find(addr)
+	if !ok {
+		return nil, 0, false
+	}
+	offset := addr - bus.mmaps[i].start
+	if offset >= bus.sizes[i] {
+		return nil, 0, false
+	}
+	return bus.devices[i], offset, true
+}
+
+// tryRead reads word, returns false on access to address without device
+func (bus *Bus) tryRead(addr uint16) (besmWord, bool) {
+	if addr == 0 || int(addr) < len(bus.consts) {
+		if val, ok := bus.constant(addr); ok {
+			return val, true
+		}
+	}
+	dev, offset, ok := bus.lookup(addr)
+	if !ok {
+		return 0, false
+	}
+	return dev.read(offset), true
+}
+
+// tryWrite writes word, returns false on access to address without device
+func (bus *Bus) tryWrite(addr uint16, value besmWord) bool {
+	if bus.isConst(addr) {
+		return true
+	}
+	dev, offset, ok := bus.lookup(addr)
+	if !ok {
+		return false
+	}
+	if _, ok := dev.(romDevice); ok {
+		return false
+	}
+	dev.write(offset, value)
+	return true
+}
+
+// program writes word on host side, ROM devices are programmed too
+func (bus *Bus) program(addr uint16, value besmWord) {
+	dev, offset, ok := bus.lookup(addr)
+	if !ok {
+		log.Printf("BUS: %s program out of device address space, 0o%o", bus.name, addr)
+		return
+	}
+	if rom, ok := dev.(romDevice); ok {
+		rom.program(offset, value)
+	} else {
+		dev.write(offset, value)
+	}
+}
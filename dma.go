@@ -0,0 +1,104 @@
+package main
+
+import "log"
+
+// dmaTransfer is a block transfer queued on channel
+type dmaTransfer struct {
+	addr  uint16     // next memory address
+	words []besmWord // transferred block
+	pos   int        // words already transferred
+	toMem bool       // device to memory direction
+	done  func([]besmWord, bool)
+}
+
+// Channel moves word blocks between devices and memory on bus while CPU
+// runs, every transferred word steals one memory cycle from CPU which owns
+// channel (CPU.addChannel)
+type Channel struct {
+	name    string
+	mem     *Bus
+	rate    int            // words per CPU step, 0 transfers whole block at once
+	queue   []*dmaTransfer // pending transfers, served in order
+	started uint64         // cycles of whole block transfers not yet reported by tick
+	owned   bool           // channel is advanced by CPU
+}
+
+func newChannel(name string, mem *Bus, rate int) *Channel {
+	return &Channel{name: name, mem: mem, rate: rate}
+}
+
+// reset aborts pending transfers, devices are told about failure so they
+// are not left busy
+func (ch *Channel) reset() {
+	queue := ch.queue
+	ch.queue = nil
+	ch.started = 0
+	for _, xfer := range queue {
+		xfer.done(xfer.words, false)
+	}
+}
+
+// busy reports pending transfers
+func (ch *Channel) busy() bool {
+	return len(ch.queue) > 0
+}
+
+// toMemory queues transfer of words to memory at addr, done is called
+// with false on bus error
+func (ch *Channel) toMemory(addr uint16, words []besmWord, done func(bool)) {
+	ch.start(&dmaTransfer{addr: addr, words: words, toMem: true, done: func(_ []besmWord, ok bool) {
+		done(ok)
+	}})
+}
+
+// fromMemory queues transfer of count words from memory at addr, done
+// gets transferred words
+func (ch *Channel) fromMemory(addr uint16, count int, done func([]besmWord, bool)) {
+	ch.start(&dmaTransfer{addr: addr, words: make([]besmWord, count), done: done})
+}
+
+// start queues transfer, transfer on channel with rate which is not
+// advanced by CPU never completes, so it fails at once
+func (ch *Channel) start(xfer *dmaTransfer) {
+	if ch.rate != 0 && !ch.owned {
+		log.Printf("CHANNEL: %s is not attached to CPU", ch.name)
+		xfer.done(xfer.words, false)
+		return
+	}
+	ch.queue = append(ch.queue, xfer)
+	if ch.rate == 0 {
+		ch.started += ch.transfer()
+	}
+}
+
+// tick transfers next words of pending blocks, returns cycles stolen
+// since previous tick
+func (ch *Channel) tick() uint64 {
+	cycles := ch.started + ch.transfer()
+	ch.started = 0
+	return cycles
+}
+
+// transfer moves words of pending blocks allowed by rate
+func (ch *Channel) transfer() uint64 {
+	var cycles uint64
+	for len(ch.queue) > 0 && (ch.rate == 0 || cycles < uint64(ch.rate)) {
+		xfer := ch.queue[0]
+		ok := true
+		if xfer.pos < len(xfer.words) {
+			if xfer.toMem {
+				ok = ch.mem.tryWrite(xfer.addr, xfer.words[xfer.pos])
+			} else {
+				xfer.words[xfer.pos], ok = ch.mem.tryRead(xfer.addr)
+			}
+			xfer.addr = (xfer.addr + 1) & MASK15
+			xfer.pos++
+			cycles++
+		}
+		if !ok || xfer.pos == len(xfer.words) {
+			ch.queue = ch.queue[1:]
+			xfer.done(xfer.words, ok)
+		}
+	}
+	return cycles
+}
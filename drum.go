@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// drum registers
+const (
+	drmTrack   = 0 // track number
+	drmSector  = 1 // sector number inside track
+	drmAddr    = 2 // memory address of transfer
+	drmCount   = 3 // words to transfer
+	drmCommand = 4 // write starts transfer
+	drmControl = 5 // interrupt enable bits
+	drmStatus  = 6 // read: status bits, write: clear written status bits
+	drmSize    = 7
+)
+
+// drum commands
+const (
+	drmRead  = 1 // drum to memory
+	drmWrite = 2 // memory to drum
+)
+
+// drum status bits
+const (
+	drmDone  = 1 // transfer completed
+	drmError = 2 // bad command, sector or memory address
+	drmBusy  = 4 // transfer is in progress
+)
+
+// bytes per word in drum image file, big endian
+const drmWordBytes = 8
+
+// blockFile is host storage of block devices, os.File implements it
+type blockFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Drum is a magnetic drum (or disk) controller transferring blocks between
+// host image file of besmWords and memory through channel
+type Drum struct {
+	name    string
+	file    blockFile
+	ch      *Channel
+	tracks  int
+	sectors int // sectors per track
+	words   int // words per sector
+	regs    [drmStatus]besmWord
+	control besmWord
+	status  besmWord
+	IntLine
+}
+
+func newDrum(name string, file blockFile, ch *Channel, tracks, sectors, words int) Drum {
+	return Drum{name: name, file: file, ch: ch, tracks: tracks, sectors: sectors, words: words}
+}
+
+func (drm *Drum) reset() {
+	drm.regs = [drmStatus]besmWord{}
+	drm.control = 0
+	drm.status = 0
+}
+
+func (drm *Drum) getName() string {
+	return drm.name
+}
+
+func (drm *Drum) getSize() uint16 {
+	return drmSize
+}
+
+func (drm *Drum) read(addr uint16) besmWord {
+	switch addr {
+	case drmControl:
+		return drm.control
+	case drmStatus:
+		return drm.status
+	}
+	if addr < drmStatus {
+		return drm.regs[addr]
+	}
+	return 0
+}
+
+func (drm *Drum) write(addr uint16, value besmWord) {
+	switch addr {
+	case drmCommand:
+		drm.regs[addr] = value
+		drm.transfer(value)
+	case drmControl:
+		drm.control = value & (drmDone | drmError)
+	case drmStatus:
+		drm.status &^= value
+	default:
+		if addr < drmStatus {
+			drm.regs[addr] = value
+		}
+	}
+}
+
+// offset returns image file offset of selected sector, false if sector
+// does not exist or transfer does not fit the drum
+func (drm *Drum) offset() (int64, bool) {
+	track, sector := drm.regs[drmTrack], drm.regs[drmSector]
+	if track >= besmWord(drm.tracks) || sector >= besmWord(drm.sectors) {
+		return 0, false
+	}
+	pos := (int(track)*drm.sectors + int(sector)) * drm.words
+	if pos+int(drm.regs[drmCount]) > drm.tracks*drm.sectors*drm.words {
+		return 0, false
+	}
+	return int64(pos) * drmWordBytes, true
+}
+
+// transfer starts command on channel, result is reported when block
+// transfer is completed
+func (drm *Drum) transfer(cmd besmWord) {
+	if drm.status&drmBusy != 0 {
+		drm.complete(drmError)
+		return
+	}
+	drm.status &^= drmDone | drmError
+	off, ok := drm.offset()
+	addr := uint16(drm.regs[drmAddr]) & MASK15
+	count := int(drm.regs[drmCount])
+	switch {
+	case !ok:
+	case cmd == drmRead:
+		var words []besmWord
+		if words, ok = drm.load(off, count); ok {
+			drm.status |= drmBusy
+			drm.ch.toMemory(addr, words, drm.done)
+			return
+		}
+	case cmd == drmWrite:
+		drm.status |= drmBusy
+		drm.ch.fromMemory(addr, count, func(words []besmWord, ok bool) {
+			drm.done(ok && drm.save(off, words))
+		})
+		return
+	}
+	drm.complete(drmDone | drmError)
+}
+
+func (drm *Drum) done(ok bool) {
+	drm.status &^= drmBusy
+	if ok {
+		drm.complete(drmDone)
+	} else {
+		drm.complete(drmDone | drmError)
+	}
+}
+
+func (drm *Drum) complete(status besmWord) {
+	drm.status |= status
+	if drm.control&status != 0 {
+		drm.request()
+	}
+}
+
+// load reads words from image file, sectors beyond end of file read as
+// zeros
+func (drm *Drum) load(off int64, count int) ([]besmWord, bool) {
+	buf := make([]byte, count*drmWordBytes)
+	if _, err := drm.file.ReadAt(buf, off); err != nil && err != io.EOF {
+		return nil, false
+	}
+	words := make([]besmWord, count)
+	for i := range words {
+		words[i] = besmWord(binary.BigEndian.Uint64(buf[i*drmWordBytes:])) & MASK48
+	}
+	return words, true
+}
+
+// save writes words to image file
+func (drm *Drum) save(off int64, words []besmWord) bool {
+	buf := make([]byte, len(words)*drmWordBytes)
+	for i, word := range words {
+		binary.BigEndian.PutUint64(buf[i*drmWordBytes:], uint64(word))
+	}
+	_, err := drm.file.WriteAt(buf, off)
+	return err == nil
+}
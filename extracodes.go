@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// extracodeHandler services extracode on host side instead of trap.
+// Executive address of extracode is available as cpu.uAddr()
+type extracodeHandler func(cpu *CPU)
+
+// setExtracode registers host handler for extracode, code is the extracode
+// number: 0o50-0o77 for short address, 0o20, 0o21 and 0o36 for long address
+// extracodes. Nil handler removes registration.
+func (cpu *CPU) setExtracode(code uint16, handler extracodeHandler) {
+	if handler == nil {
+		delete(cpu.extracodes, code)
+		return
+	}
+	cpu.extracodes[code] = handler
+}
+
+// setExtTrap enables trap of extracodes without host handler to vector
+// table at base on instruction bus, return word is saved at save address
+// on data bus. Both must be mapped to devices.
+func (cpu *CPU) setExtTrap(base uint16, save uint16) error {
+	for _, addr := range []uint16{base, base + 0o77} {
+		if _, _, ok := cpu.ibus.lookup(addr); !ok {
+			return fmt.Errorf("extracode vector %05o is not mapped on %s", addr, cpu.ibus.name)
+		}
+	}
+	if _, _, ok := cpu.dbus.lookup(save); !ok || cpu.dbus.isConst(save) {
+		return fmt.Errorf("extracode return word %05o is not mapped on %s", save, cpu.dbus.name)
+	}
+	cpu.extBase = base
+	cpu.extSave = save
+	cpu.extTrap = true
+	return nil
+}
+
+// extracode return word bits, low 15 bits are return address
+const (
+	extRetRight = 0o100000 // return to right instruction
+	extRetUser  = 0o200000 // return to user mode
+)
+
+// extracode calls host handler for extracode or traps to vector table.
+// On trap executive address is saved to M14, return word to memory at
+// extSave and execution continues from extBase+code in supervisor mode
+// routine, which returns with IJ 0(2) using return word.
+func (cpu *CPU) extracode(code uint16) {
+	if handler, ok := cpu.extracodes[code]; ok {
+		handler(cpu)
+		return
+	}
+	if !cpu.extTrap {
+		log.Printf("Unimplemented extracode: E%02o - %s", code, decodeOp(cpu.ir))
+		cpu.Running = false
+		return
+	}
+	if cpu.inExtracode {
+		log.Printf("Nested extracode E%02o at %05o", code, cpu.PC)
+		cpu.Running = false
+		return
+	}
+	// next instruction after extracode
+	ret := besmWord(cpu.pcNext)
+	if cpu.right {
+		ret |= extRetRight
+	}
+	if cpu.user {
+		ret |= extRetUser
+	}
+	cpu.user = false
+	cpu.store(cpu.extSave, ret)
+	cpu.inExtracode = true
+	cpu.M[14] = cpu.uAddr()
+	cpu.jumpTo(cpu.extBase+code, false)
+}
+
+// printExtracode returns handler printing number from memory at executive
+// address, or ACC when executive address is zero
+func printExtracode(w io.Writer) extracodeHandler {
+	return func(cpu *CPU) {
+		val := cpu.Acc
+		if addr := cpu.uAddr(); addr != 0 {
+			val = cpu.load(addr)
+		}
+		fmt.Fprintln(w, printBesmNumber(val))
+	}
+}
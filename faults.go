@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Machine fault kinds. When faults are trapped, kind is the interrupt line
+// reserved for faults (below intFaultLines).
+const (
+	faultOverflow   = iota // exponent overflow
+	faultDivZero           // division by zero
+	faultPrivileged        // privileged instruction in user mode
+	faultProtection        // write outside of user mode region
+	faultPage              // access to unmapped page
+	faultBus               // access to bus address without device
+)
+
+var faultNames = [...]string{
+	"exponent overflow", "division by zero", "privileged instruction", "write protection",
+	"page fault", "bus error",
+}
+
+// Fault holds state of CPU at machine fault
+type Fault struct {
+	kind  int
+	PC    uint16
+	right bool     // fault at right instruction
+	ir    besmWord // faulting instruction
+	addr  uint16   // faulting address
+}
+
+func (f *Fault) String() string {
+	half := "left"
+	if f.right {
+		half = "right"
+	}
+	return fmt.Sprintf("%s at %05o %s: %s addr: %05o", faultNames[f.kind], f.PC, half, decodeOp(f.ir), f.addr)
+}
+
+// fault records machine fault of current instruction
+func (cpu *CPU) fault(kind int, addr uint16) {
+	// right flag is already switched to next instruction
+	cpu.raiseFault(&Fault{kind, cpu.PC, !cpu.right, cpu.ir, addr}, cpu.faultTrap)
+}
+
+// abort records restartable fault of current instruction, instruction
+// changes nothing and is executed again after return from fault handler,
+// only first fault of instruction is recorded
+func (cpu *CPU) abort(kind int, addr uint16) {
+	if cpu.aborted {
+		return
+	}
+	cpu.aborted = true
+	cpu.fault(kind, addr)
+}
+
+// busFault records access error of current instruction to bus address
+func (cpu *CPU) busFault(bus *Bus, addr uint16) {
+	cpu.raiseFault(&Fault{faultBus, cpu.PC, !cpu.right, cpu.ir, addr}, bus.policy == busInterrupt)
+}
+
+// raiseFault saves fault record, then either raises interrupt (if trap is
+// set and CPU is not in interrupt) or stops CPU
+func (cpu *CPU) raiseFault(f *Fault, trap bool) {
+	cpu.lastFault = f
+	if trap && cpu.rrReg&0b1000000 == 0 {
+		cpu.intc.raise(f.kind)
+		return
+	}
+	if trap {
+		log.Println("FAULT:", cpu.lastFault, "(in interrupt, trap suppressed)")
+	} else {
+		log.Println("FAULT:", cpu.lastFault)
+	}
+	cpu.Running = false
+}
@@ -0,0 +1,110 @@
+package main
+
+import "fmt"
+
+// IntController latches interrupt requests raised by devices
+type IntController struct {
+	pending uint32 // requested interrupt lines
+	level   uint32 // asserted level sensitive lines
+	mask    uint32 // disabled interrupt lines
+}
+
+// number of interrupt lines
+const intLines = 32
+
+// lines below intFaultLines are reserved for machine faults, fault kind is
+// the line number, devices use lines from intFaultLines
+const intFaultLines = 8
+
+func newIntController() *IntController {
+	return &IntController{}
+}
+
+func (ic *IntController) reset() {
+	ic.pending = 0
+	ic.level = 0
+}
+
+// raise requests interrupt on line
+func (ic *IntController) raise(line int) {
+	ic.pending |= 1 << uint(line)
+}
+
+// clear drops not yet served request on line
+func (ic *IntController) clear(line int) {
+	ic.pending &^= 1 << uint(line)
+}
+
+// assert holds request on line until deassert, request is served again
+// after return from interrupt while line is asserted
+func (ic *IntController) assert(line int) {
+	ic.level |= 1 << uint(line)
+}
+
+// deassert drops request held by assert
+func (ic *IntController) deassert(line int) {
+	ic.level &^= 1 << uint(line)
+}
+
+// setMask disables (masked = true) or enables interrupt line
+func (ic *IntController) setMask(line int, masked bool) {
+	if masked {
+		ic.mask |= 1 << uint(line)
+	} else {
+		ic.mask &^= 1 << uint(line)
+	}
+}
+
+// acknowledge returns pending enabled line with highest priority (lowest
+// number) and drops its request
+func (ic *IntController) acknowledge() (int, bool) {
+	req := (ic.pending | ic.level) &^ ic.mask
+	if req == 0 {
+		return 0, false
+	}
+	for line := 0; line < intLines; line++ {
+		if req&(1<<uint(line)) != 0 {
+			ic.clear(line)
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+// IntLine connects device to interrupt controller line, devices embed it
+type IntLine struct {
+	intc   *IntController
+	intNum int
+}
+
+// setInterrupt connects device to interrupt line, lines reserved for
+// machine faults are rejected
+func (il *IntLine) setInterrupt(ic *IntController, line int) error {
+	if line < intFaultLines || line >= intLines {
+		return fmt.Errorf("interrupt line %d is not available for devices", line)
+	}
+	il.intc = ic
+	il.intNum = line
+	return nil
+}
+
+// request raises interrupt once
+func (il *IntLine) request() {
+	if il.intc != nil {
+		il.intc.raise(il.intNum)
+	}
+}
+
+// assert holds interrupt request until deassert
+func (il *IntLine) assert() {
+	if il.intc != nil {
+		il.intc.assert(il.intNum)
+	}
+}
+
+// deassert drops interrupt request
+func (il *IntLine) deassert() {
+	if il.intc != nil {
+		il.intc.deassert(il.intNum)
+	}
+}
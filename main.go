@@ -1,19 +1,48 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// test programs in tests directory
+var testPrograms = []string{"tests/a+x_a-x_x-a.oct", "tests/aax_aox_aex.oct", "tests/addr0.oct", "tests/apx_aux.oct", "tests/stack.oct", "tests/mul_div.oct", "tests/eaddx_esubx.oct", "tests/avx_amx_mod.oct", "tests/vlm_vzm.oct"}
+
+// console reads host input only on request, reading blocks simulation
+var consoleInput = flag.Bool("input", false, "console reads standard input")
 
 func main() {
-	rom := newMemory("ROM", 1024)
+	flag.Parse()
+	rom := newROM("ROM", 1024)
 	ram := newMemory("RAM", 1024)
 
 	ibus := newBus("IBUS")
 	dbus := newBus("DBUS")
-	ibus.attach(MemRegion{0, 1023}, &rom)
-	dbus.attach(MemRegion{0o2000, 0o2000 + 1023}, &ram)
+	if err := ibus.attach(MemRegion{0, 1023}, &rom); err != nil {
+		log.Fatal(err)
+	}
+	if err := dbus.attach(MemRegion{0o2000, 0o2000 + 1023}, &ram); err != nil {
+		log.Fatal(err)
+	}
+	var in io.Reader
+	if *consoleInput {
+		in = os.Stdin
+	}
+	con := newConsole("CONSOLE", in, os.Stdout, asciiCharset{})
+	if err := dbus.attach(MemRegion{0o4000, 0o4000 + conSize - 1}, &con); err != nil {
+		log.Fatal(err)
+	}
+	tmr := newTimer("TIMER")
+	if err := dbus.attach(MemRegion{0o4010, 0o4010 + tmrSize - 1}, &tmr); err != nil {
+		log.Fatal(err)
+	}
 	cpu := newCPU(ibus, dbus)
+	cpu.setExtracode(0o64, printExtracode(os.Stdout))
 
-	tests := []string{"tests/a+x_a-x_x-a.oct", "tests/aax_aox_aex.oct", "tests/addr0.oct", "tests/apx_aux.oct", "tests/stack.oct"}
-	for _, t := range tests {
+	for _, t := range testPrograms {
 		fmt.Println("Begin of:", t)
 		cpu.reset()
 		loadOct(t, ibus, dbus)
@@ -3,7 +3,10 @@ package main
 import (
 	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -207,3 +210,1490 @@ func TestXTS(t *testing.T) {
 		t.Error("XTS broken")
 	}
 }
+
+func TestMUL(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// (1 - 2^-40) * (1 - 2^-40) = 1 - 2^-39 + 2^-80
+	cpu.Acc = 0o4000000000000000 | MASK40
+	ibus.write(0o55, 0o4000000000000000|MASK40)
+	instr, _ := emitOp(0, OpMUL, 0o55)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.Rmr&MASK40 != 1 {
+		t.Errorf("MUL low half of product is not in RMR: %016o", cpu.Rmr)
+	}
+	if cpu.Acc != 0o4000000000000000|MASK40 {
+		t.Errorf("MUL rounding failed: %016o", cpu.Acc)
+	}
+	if cpu.rrReg&0b11100 != 0b01000 {
+		t.Error("Multiplicative mode flag is not set")
+	}
+	// without rounding
+	cpu.reset()
+	cpu.rrReg = 2
+	cpu.Acc = 0o4000000000000000 | MASK40
+	cpu.step()
+	if cpu.Acc != 0o4000000000000000|(MASK40-1) {
+		t.Errorf("MUL without rounding failed: %016o", cpu.Acc)
+	}
+}
+
+func TestDIV(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// 6 / -3 = -2
+	cpu.Acc = 0o4154000000000000
+	ibus.write(0o55, 0o4124000000000000)
+	instr, _ := emitOp(0, OpDIV, 0o55)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.Acc != 0o4060000000000000 {
+		t.Errorf("DIV 6 / -3 failed: %016o", cpu.Acc)
+	}
+	if cpu.rrReg&0b11100 != 0b01000 {
+		t.Error("Multiplicative mode flag is not set")
+	}
+	// division by zero stops CPU
+	cpu.reset()
+	cpu.Running = true
+	ibus.write(0o55, 0)
+	cpu.step()
+	if cpu.Running {
+		t.Error("Division by zero does not stop CPU")
+	}
+}
+
+func TestEADDNOverflow(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// 0.5 * 2^63 multiplied by 2^1 overflows exponent
+	cpu.Acc = 0o7750000000000000
+	cpu.Running = true
+	instr, _ := emitOp(0, OpEADDN, 0o101)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.Running {
+		t.Error("Exponent overflow does not stop CPU")
+	}
+	if cpu.rrReg&0b11100 != 0b01000 {
+		t.Error("Multiplicative mode flag is not set")
+	}
+}
+
+func TestITSSTI(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// ITS 3: push ACC, load M3 to ACC
+	cpu.Acc = 0o6767
+	cpu.M[3] = 0o123
+	cpu.M[15] = 0o100
+	instr, _ := emitOp(0, OpITS, 3)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.Acc != 0o123 || cpu.M[15] != 0o101 || ibus.read(0o100) != 0o6767 || cpu.rrReg&4 == 0 {
+		cpu.state()
+		t.Error("ITS broken")
+	}
+	// STI 4: load M4 from ACC, pop ACC
+	cpu.reset()
+	cpu.Acc = 0o321
+	cpu.M[15] = 0o101
+	instr, _ = emitOp(0, OpSTI, 4)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.M[4] != 0o321 || cpu.Acc != 0o6767 || cpu.M[15] != 0o100 || cpu.rrReg&4 == 0 {
+		cpu.state()
+		t.Error("STI broken")
+	}
+	// STI 15: load M15 from ACC, ACC from new top of stack
+	cpu.reset()
+	cpu.Acc = 0o100
+	instr, _ = emitOp(0, OpSTI, 15)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.M[15] != 0o100 || cpu.Acc != 0o6767 {
+		cpu.state()
+		t.Error("STI to M15 broken")
+	}
+}
+
+func TestAVX(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	instr, _ := emitOp(0, OpAVX, 0o55)
+	mem.write(1, instr<<24)
+	for _, v := range []struct{ acc, op, res besmWord }{
+		{0o4114000000000000, 0o4020000000000000, 0o4124000000000000}, // 3 by -1 = -3
+		{0o4114000000000000, 0o4050000000000000, 0o4114000000000000}, // 3 by 1 = 3
+		{0o4124000000000000, 0o4060000000000000, 0o4114000000000000}, // -3 by -2 = 3
+	} {
+		cpu.reset()
+		cpu.Acc = v.acc
+		mem.write(0o55, v.op)
+		cpu.step()
+		if cpu.Acc != v.res {
+			t.Errorf("AVX %016o by %016o = %016o", v.acc, v.op, cpu.Acc)
+		}
+		if cpu.rrReg&0b11100 != 0b10000 {
+			t.Error("Additive mode flag is not set")
+		}
+	}
+}
+
+func TestAMX(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	instr, _ := emitOp(0, OpAMX, 0o55)
+	mem.write(1, instr<<24)
+	for _, v := range []struct{ acc, op, res besmWord }{
+		{0o4124000000000000, 0o4050000000000000, 0o4110000000000000}, // |-3| - |1| = 2
+		{0o4050000000000000, 0o4124000000000000, 0o4060000000000000}, // |1| - |-3| = -2
+		{0o4060000000000000, 0o4110000000000000, 0},                  // |-2| - |2| = 0
+	} {
+		cpu.reset()
+		cpu.Acc = v.acc
+		mem.write(0o55, v.op)
+		cpu.step()
+		if cpu.Acc != v.res {
+			t.Errorf("AMX %016o by %016o = %016o", v.acc, v.op, cpu.Acc)
+		}
+		if cpu.rrReg&0b11100 != 0b10000 {
+			t.Error("Additive mode flag is not set")
+		}
+	}
+}
+
+func TestMOD(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.M[1] = 5
+	// MOD 50(1) at left modifies address of XTA 10 at right
+	left, _ := emitOp(1, OpMOD, 0o50)
+	right, _ := emitOp(0, OpXTA, 0o10)
+	mem.write(1, left<<24|right)
+	// only low 15 bits of word are modifier
+	mem.write(0o55, 0o7777000000000070)
+	mem.write(0o100, 0o1234)
+	mem.write(0o10, 0o4321)
+	// interrupt is not served between MOD and modified instruction
+	cpu.intc.raise(8)
+	cpu.rrReg = 0b1000000
+	cpu.step()
+	if !cpu.cActive || cpu.cReg != 0o70 {
+		t.Error("MOD does not load modifier")
+	}
+	cpu.rrReg = 0
+	cpu.step()
+	if cpu.Acc != 0o1234 || cpu.cActive {
+		cpu.state()
+		t.Error("MOD does not modify address of next instruction")
+	}
+	// modifier is applied to next instruction only
+	xta, _ := emitOp(0, OpXTA, 0o10)
+	mem.write(2, xta<<24)
+	cpu.intc.clear(8)
+	cpu.step()
+	if cpu.Acc != 0o4321 {
+		t.Error("MOD modifies address of second instruction")
+	}
+}
+
+func TestMTJ(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.M[2] = 0o777
+	cpu.M[5] = 0o10
+	// MTJ 5(2): M5 = M2, address is not modified by M2
+	instr, _ := emitOp(2, OpMTJ, 5)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.M[5] != 0o777 {
+		t.Error("MTJ broken")
+	}
+	// M0 is always zero
+	cpu.reset()
+	cpu.M[2] = 0o777
+	instr, _ = emitOp(2, OpMTJ, 0)
+	mem.write(1, instr<<24)
+	cpu.step()
+	if cpu.M[0] != 0 {
+		t.Error("MTJ modified M0")
+	}
+}
+
+func TestIJ(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.Running = true
+	instr, _ := emitOp(0, OpIJ, 0)
+	mem.write(1, instr<<24)
+	// return to right instruction of word at address 5
+	left, _ := emitOp(0, OpUTC, 0)
+	right, _ := emitOp(3, OpVTM, 0o77)
+	mem.write(5, left<<24|right)
+	cpu.rrReg = 0b1000000
+	cpu.intPC = 5
+	cpu.intRight = true
+	cpu.step()
+	if cpu.PC != 5 || !cpu.right || cpu.rrReg&0b1000000 != 0 {
+		cpu.state()
+		t.Error("IJ return failed")
+	}
+	cpu.step()
+	if cpu.M[3] != 0o77 || cpu.PC != 6 {
+		cpu.state()
+		t.Error("Right instruction is not executed after IJ")
+	}
+	// IJ outside of interrupt stops CPU
+	cpu.reset()
+	cpu.Running = true
+	cpu.step()
+	if cpu.Running {
+		t.Error("IJ outside of interrupt does not stop CPU")
+	}
+}
+
+func TestExtracode(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// E51 12(0) at left, VTM 1(3) at right
+	left, _ := emitOp(0, OpE51, 0o12)
+	right, _ := emitOp(3, OpVTM, 1)
+	mem.write(1, left<<24|right)
+	// without trap and handler extracode stops CPU
+	cpu.Running = true
+	cpu.step()
+	if cpu.Running {
+		t.Error("Unhandled extracode does not stop CPU")
+	}
+	// host handler
+	cpu.reset()
+	called := uint16(0)
+	cpu.setExtracode(0o51, func(cpu *CPU) {
+		called = cpu.uAddr()
+	})
+	cpu.step()
+	if called != 0o12 || cpu.PC != 1 || !cpu.right {
+		t.Error("Extracode host handler is not called")
+	}
+	// hardware trap to vector table, handler returns with IJ 0(2)
+	cpu.reset()
+	cpu.setExtracode(0o51, nil)
+	// vector table and return word must be mapped
+	if cpu.setExtTrap(0o1750, 0o477) == nil || cpu.setExtTrap(0o500, 0o2000) == nil || cpu.extTrap {
+		t.Error("Extracode trap to unmapped address is enabled")
+	}
+	if err := cpu.setExtTrap(0o500, 0o477); err != nil {
+		t.Fatal(err)
+	}
+	ij, _ := emitOp(2, OpIJ, 0)
+	mem.write(cpu.extBase+0o51, ij<<24)
+	cpu.step()
+	if cpu.PC != cpu.extBase+0o51 || cpu.right || cpu.M[14] != 0o12 || !cpu.inExtracode {
+		cpu.state()
+		t.Error("Extracode trap failed")
+	}
+	if mem.read(cpu.extSave) != 1|extRetRight {
+		t.Errorf("Extracode return word is %o", mem.read(cpu.extSave))
+	}
+	cpu.step()
+	cpu.step()
+	if cpu.M[3] != 1 || cpu.inExtracode {
+		cpu.state()
+		t.Error("Return from extracode failed")
+	}
+	// routine changes return word to skip right instruction
+	cpu.reset()
+	vtm, _ := emitOp(4, OpVTM, 2)
+	mem.write(2, vtm<<24)
+	cpu.step()
+	mem.write(cpu.extSave, 2)
+	cpu.step()
+	cpu.step()
+	if cpu.M[3] != 0 || cpu.M[4] != 2 {
+		cpu.state()
+		t.Error("Return by changed return word failed")
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// VTM 1(3) at left, VTM 2(4) at right
+	left, _ := emitOp(3, OpVTM, 1)
+	right, _ := emitOp(4, OpVTM, 2)
+	mem.write(1, left<<24|right)
+	// interrupt handler: XTA 0, IJ
+	xta, _ := emitOp(0, OpXTA, 0)
+	ij, _ := emitOp(0, OpIJ, 0)
+	mem.write(cpu.intBase+8, xta<<24|ij)
+	cpu.rrReg = 0b10000 // additive mode
+	cpu.step()
+	cpu.intc.raise(8)
+	cpu.step()
+	if cpu.rrReg&0b1000000 == 0 || cpu.intPC != 1 || !cpu.intRight {
+		cpu.state()
+		t.Error("Interrupt entry failed")
+	}
+	// interrupts are not nested
+	cpu.intc.raise(9)
+	cpu.step()
+	if cpu.PC != 1 || !cpu.right || cpu.rrReg != 0b10000 {
+		cpu.state()
+		t.Error("Return from interrupt failed")
+	}
+	// pending request is served right after return
+	cpu.step()
+	if cpu.PC != cpu.intBase+9 || cpu.intPC != 1 || !cpu.intRight {
+		cpu.state()
+		t.Error("Pending interrupt is not served")
+	}
+	// masked line is not served
+	cpu.reset()
+	cpu.intc.setMask(8, true)
+	cpu.intc.raise(8)
+	cpu.step()
+	cpu.step()
+	if cpu.M[3] != 1 || cpu.M[4] != 2 || cpu.rrReg&0b1000000 != 0 {
+		cpu.state()
+		t.Error("Masked interrupt is served")
+	}
+}
+
+func TestXTRRTE(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// mode is taken from exponent bits
+	ibus.write(0o55, 0o1240000000000077)
+	left, _ := emitOp(0, OpXTR, 0o55)
+	right, _ := emitOp(0, OpRTE, 0o77)
+	mem.write(1, left<<24|right)
+	cpu.rrReg = 0b1000000
+	cpu.step()
+	if cpu.rrReg != 0b1000000|0o25 {
+		t.Errorf("XTR failed: RR = %07b", cpu.rrReg)
+	}
+	cpu.step()
+	if cpu.Acc != 0o1240000000000000 {
+		t.Errorf("RTE failed: ACC = %016o", cpu.Acc)
+	}
+	// compatibility mode uses low bits
+	cpu.reset()
+	cpu.rrCompat = true
+	cpu.step()
+	if cpu.rrReg != 0o77 {
+		t.Errorf("XTR compat failed: RR = %07b", cpu.rrReg)
+	}
+	cpu.step()
+	if cpu.Acc != 0o77 {
+		t.Errorf("RTE compat failed: ACC = %016o", cpu.Acc)
+	}
+}
+
+func TestOverflowFault(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// 0.5 * 2^63 + 0.5 * 2^63 overflows exponent
+	cpu.Acc = 0o7750000000000000
+	ibus.write(0o55, 0o7750000000000000)
+	left, _ := emitOp(0, OpUTC, 0)
+	right, _ := emitOp(0, OpADD, 0o55)
+	mem.write(1, left<<24|right)
+	cpu.Running = true
+	cpu.step()
+	cpu.step()
+	if cpu.Running || cpu.lastFault == nil {
+		t.Fatal("Exponent overflow does not stop CPU")
+	}
+	if cpu.lastFault.kind != faultOverflow || cpu.lastFault.PC != 1 || !cpu.lastFault.right || cpu.lastFault.addr != 0o55 {
+		t.Errorf("Wrong fault record: %s", cpu.lastFault)
+	}
+	// trap to interrupt vector
+	cpu.reset()
+	cpu.faultTrap = true
+	cpu.Acc = 0o7750000000000000
+	cpu.Running = true
+	cpu.step()
+	cpu.step()
+	cpu.step()
+	if !cpu.Running || cpu.rrReg&0b1000000 == 0 || cpu.PC != cpu.intBase+faultOverflow || cpu.intPC != 2 {
+		cpu.state()
+		t.Error("Exponent overflow is not trapped")
+	}
+	// fault in interrupt stops CPU and reports suppressed trap
+	var report strings.Builder
+	log.SetOutput(&report)
+	cpu.Acc = 0o7750000000000000
+	cpu.PC, cpu.right = 1, false
+	cpu.step()
+	cpu.step()
+	log.SetOutput(ioutil.Discard)
+	if cpu.Running || !strings.Contains(report.String(), "trap suppressed") {
+		t.Errorf("Fault in interrupt is not reported: %q", report.String())
+	}
+}
+
+func TestNegateOverflow(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	// -1 * 2^63 can not be negated without exponent overflow
+	const minNumber = 0o7760000000000000
+	tests := []struct {
+		op       uint16
+		acc, val besmWord
+	}{
+		{OpSUB, 0, minNumber},
+		{OpRSUB, minNumber, 0},
+		{OpAMX, minNumber, 0},
+		{OpAVX, minNumber, 0o4020000000000000},
+	}
+	for _, tt := range tests {
+		cpu.reset()
+		cpu.Acc = tt.acc
+		ibus.write(0o55, tt.val)
+		op, _ := emitOp(0, tt.op, 0o55)
+		mem.write(1, op<<24)
+		cpu.Running = true
+		cpu.step()
+		if cpu.Running || cpu.lastFault == nil || cpu.lastFault.kind != faultOverflow || cpu.Acc != tt.acc {
+			t.Errorf("Negation overflow of %03o is not detected: ACC = %016o", tt.op, cpu.Acc)
+		}
+	}
+	// no overflow for 0.5 * 2^63
+	cpu.reset()
+	cpu.Acc = 0
+	ibus.write(0o55, 0o7750000000000000)
+	op, _ := emitOp(0, OpSUB, 0o55)
+	mem.write(1, op<<24)
+	cpu.Running = true
+	cpu.step()
+	if !cpu.Running || cpu.Acc != 0o7720000000000000 {
+		t.Errorf("SUB failed: ACC = %016o", cpu.Acc)
+	}
+}
+
+func TestUserMode(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.userLo = 0o100
+	cpu.userHi = 0o177
+	// supervisor enters user program at 10
+	ij, _ := emitOp(1, OpIJ, 0o10)
+	mem.write(1, ij<<24)
+	// ATX 100 is allowed, ATX 200 is not
+	atx, _ := emitOp(0, OpATX, 0o100)
+	atx2, _ := emitOp(0, OpATX, 0o200)
+	mem.write(0o10, atx<<24|atx2)
+	stop, _ := emitOp(0, OpSTOP, 0)
+	mem.write(0o11, stop<<24)
+	cpu.Acc = 0o55
+	cpu.Running = true
+	cpu.step()
+	if !cpu.user || cpu.PC != 0o10 {
+		t.Fatal("IJ to user mode failed")
+	}
+	cpu.step()
+	cpu.step()
+	if cpu.Running || mem.read(0o100) != 0o55 || mem.read(0o200) != 0 {
+		t.Error("User mode write protection failed")
+	}
+	if cpu.lastFault == nil || cpu.lastFault.kind != faultProtection || cpu.lastFault.addr != 0o200 {
+		t.Errorf("Wrong fault record: %s", cpu.lastFault)
+	}
+	// faulting instruction is not completed to be restarted
+	if cpu.PC != 0o10 || !cpu.right {
+		t.Error("Write protection fault does not stop at faulting instruction")
+	}
+	// privileged instruction is trapped to supervisor
+	cpu.PC, cpu.right = 0o11, false
+	cpu.Running = true
+	cpu.faultTrap = true
+	cpu.lastFault = nil
+	cpu.step()
+	if !cpu.Running || cpu.lastFault == nil || cpu.lastFault.kind != faultPrivileged {
+		t.Fatal("STOP is allowed in user mode")
+	}
+	mem.write(cpu.intBase+faultPrivileged, stop<<24)
+	cpu.step()
+	if cpu.user || cpu.Running || !cpu.intUser {
+		t.Error("Privileged instruction fault is not served in supervisor mode")
+	}
+}
+
+func TestMMU(t *testing.T) {
+	mem := newMemory("MEM", 4096)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 4095}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.mmu.enabled = true
+	// supervisor maps virtual page 0 to physical page 2 and enters user mode
+	xta, _ := emitOp(0, OpXTA, 0o100)
+	wpg, _ := emitOp(0, OpE33, 0)
+	ij, _ := emitOp(1, OpIJ, 0o10)
+	mem.write(1, xta<<24|wpg)
+	mem.write(2, ij<<24)
+	mem.write(0o100, pageValid|2)
+	// user program reads mapped and unmapped pages
+	xta, _ = emitOp(0, OpXTA, 0o20)
+	xta2, _ := emitOp(0, OpXTA, 0o2000)
+	mem.write(0o4010, xta<<24|xta2)
+	mem.write(0o4020, 0o1234)
+	cpu.Running = true
+	cpu.step()
+	cpu.step()
+	cpu.step()
+	if !strings.Contains(cpu.mmu.String(), "00000-01777 -> 04000-05777") {
+		t.Errorf("Wrong MMU mapping:\n%s", cpu.mmu)
+	}
+	cpu.step()
+	if cpu.Acc != 0o1234 {
+		t.Errorf("Read through MMU failed: %016o", cpu.Acc)
+	}
+	cpu.step()
+	if cpu.Running || cpu.lastFault == nil || cpu.lastFault.kind != faultPage || cpu.lastFault.addr != 0o2000 {
+		t.Errorf("Page fault is not raised: %s", cpu.lastFault)
+	}
+	// page registers are privileged
+	cpu.Running = true
+	wpg, _ = emitOp(0, OpE33, 1)
+	mem.write(0o4010, wpg<<24)
+	cpu.PC = 0o10
+	cpu.right = false
+	cpu.step()
+	if cpu.Running || cpu.lastFault.kind != faultPrivileged {
+		t.Error("Page register is written in user mode")
+	}
+	// host side mapping
+	cpu.mmu.setPage(2, 3)
+	if addr, ok := cpu.mmu.translate(0o4005); !ok || addr != 0o6005 {
+		t.Errorf("Host mapped page translation failed: %05o", addr)
+	}
+	if !strings.Contains(cpu.mmu.String(), "04000-05777 -> 06000-07777") {
+		t.Errorf("Wrong MMU mapping:\n%s", cpu.mmu)
+	}
+	cpu.mmu.unmapPage(2)
+	if _, ok := cpu.mmu.translate(0o4005); ok {
+		t.Error("Unmapped page is translated")
+	}
+}
+
+func TestRestartFault(t *testing.T) {
+	mem := newMemory("MEM", 4096)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 4095}, &mem)
+	cpu := newCPU(ibus, ibus)
+	cpu.mmu.enabled = true
+	cpu.faultTrap = true
+	// user program at virtual 10 pushes ACC and reads unmapped page 1
+	cpu.mmu.setPage(0, 2)
+	xts, _ := emitOp(0, OpXTS, 0o2000)
+	mem.write(0o4010, xts<<24)
+	mem.write(0o6000, 0o1234)
+	// page fault handler maps page 1 and returns to faulting instruction
+	xta, _ := emitOp(0, OpXTA, 0o100)
+	wpg, _ := emitOp(0, OpE33, 1)
+	ij, _ := emitOp(0, OpIJ, 0)
+	mem.write(cpu.intBase+faultPage, xta<<24|wpg)
+	mem.write(cpu.intBase+faultPage+1, ij<<24)
+	mem.write(0o100, pageValid|3)
+	cpu.user = true
+	cpu.userHi = 0o77777
+	cpu.PC = 0o10
+	cpu.Acc = 0o55
+	cpu.M[15] = 0o100
+	cpu.Running = true
+	cpu.step()
+	if cpu.lastFault == nil || cpu.lastFault.kind != faultPage || cpu.lastFault.PC != 0o10 || cpu.lastFault.right {
+		t.Fatalf("Wrong fault record: %s", cpu.lastFault)
+	}
+	if cpu.Acc != 0o55 || cpu.M[15] != 0o100 || cpu.PC != 0o10 || cpu.right {
+		cpu.state()
+		t.Error("Faulting instruction changes registers")
+	}
+	cpu.step()
+	if cpu.intPC != 0o10 || cpu.intRight || !cpu.intUser {
+		t.Error("Wrong return state of fault interrupt")
+	}
+	cpu.step()
+	cpu.step()
+	cpu.step()
+	if !cpu.Running || cpu.Acc != 0o1234 || cpu.M[15] != 0o101 {
+		cpu.state()
+		t.Error("Faulting instruction is not restarted")
+	}
+}
+
+func TestBusPolicy(t *testing.T) {
+	rom := newMemory("ROM", 16)
+	ram := newMemory("RAM", 16)
+	ibus := newBus("IBUS")
+	dbus := newBus("DBUS")
+	ibus.attach(MemRegion{0, 15}, &rom)
+	// region is larger than memory
+	dbus.attach(MemRegion{0o100, 0o177}, &ram)
+	cpu := newCPU(ibus, dbus)
+	xta, _ := emitOp(0, OpXTA, 0o120)
+	atx, _ := emitOp(0, OpATX, 0o200)
+	rom.write(1, xta<<24|atx)
+	// garbage by default
+	cpu.Running = true
+	cpu.step()
+	if !cpu.Running || cpu.Acc != 0xDEADBEEF {
+		t.Error("Default bus policy is changed")
+	}
+	// stop with fault record
+	cpu.reset()
+	dbus.policy = busStop
+	cpu.faultTrap = true
+	cpu.Running = true
+	cpu.step()
+	if cpu.Running || cpu.lastFault == nil || cpu.lastFault.kind != faultBus || cpu.lastFault.addr != 0o120 || cpu.lastFault.right {
+		t.Errorf("Bus stop policy failed: %s", cpu.lastFault)
+	}
+	// interrupt
+	cpu.Running = true
+	dbus.policy = busInterrupt
+	cpu.faultTrap = false
+	cpu.step()
+	cpu.step()
+	if !cpu.Running || cpu.PC != cpu.intBase+faultBus || cpu.lastFault.addr != 0o200 || !cpu.lastFault.right {
+		cpu.state()
+		t.Errorf("Bus interrupt policy failed: %s", cpu.lastFault)
+	}
+}
+
+func TestROM(t *testing.T) {
+	rom := newROM("ROM", 16)
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 15}, &rom)
+	cpu := newCPU(ibus, ibus)
+	atx, _ := emitOp(0, OpATX, 0o10)
+	ibus.program(1, atx<<24)
+	ibus.program(0o10, 0o1234)
+	if rom.read(1) != atx<<24 || ibus.read(0o10) != 0o1234 {
+		t.Fatal("ROM programming failed")
+	}
+	// writes are ignored
+	cpu.Acc = 0o4321
+	cpu.Running = true
+	cpu.step()
+	ibus.reset()
+	if !cpu.Running || rom.read(0o10) != 0o1234 {
+		t.Error("ROM is written by CPU")
+	}
+	// writes are rejected with bus policy
+	cpu.reset()
+	ibus.policy = busStop
+	cpu.Running = true
+	cpu.step()
+	if cpu.Running || cpu.lastFault == nil || cpu.lastFault.kind != faultBus || rom.read(0o10) != 0o1234 {
+		t.Error("ROM write fault is not raised")
+	}
+}
+
+func TestBusAttach(t *testing.T) {
+	bus := newBus("DBUS")
+	a := newMemory("A", 16)
+	b := newMemory("B", 16)
+	if err := bus.attach(MemRegion{0o20, 0o37}, &a); err != nil {
+		t.Fatal(err)
+	}
+	overlaps := []MemRegion{{0o20, 0o37}, {0o10, 0o20}, {0o37, 0o50}, {0o25, 0o30}, {0o10, 0o50}, {0o40, 0o30}}
+	for _, r := range overlaps {
+		if bus.attach(r, &b) == nil {
+			t.Errorf("Region %05o-%05o attached over A", r.start, r.end)
+		}
+	}
+	if err := bus.attach(MemRegion{0o0, 0o17}, &b); err != nil {
+		t.Fatal(err)
+	}
+	if bus.String() != "DBUS:\n00000-00017 B\n00020-00037 A\n" {
+		t.Errorf("Wrong bus map:\n%s", bus)
+	}
+}
+
+// BenchmarkPrograms runs test programs on machine with 16 register
+// devices attached to data bus before RAM
+func BenchmarkPrograms(b *testing.B) {
+	for _, name := range testPrograms {
+		b.Run(name[len("tests/"):], func(b *testing.B) {
+			rom := newROM("ROM", 1024)
+			ram := newMemory("RAM", 1024)
+			ibus := newBus("IBUS")
+			dbus := newBus("DBUS")
+			ibus.attach(MemRegion{0, 1023}, &rom)
+			regs := make([]Memory, 16)
+			for i := range regs {
+				regs[i] = newMemory("REG", 4)
+				dbus.attach(MemRegion{uint16(0o1000 + i*4), uint16(0o1000 + i*4 + 3)}, &regs[i])
+			}
+			dbus.attach(MemRegion{0o2000, 0o2000 + 1023}, &ram)
+			cpu := newCPU(ibus, dbus)
+			loadOct(name, ibus, dbus)
+			data := append([]besmWord(nil), ram.data...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(ram.data, data)
+				cpu.reset()
+				cpu.run()
+			}
+		})
+	}
+}
+
+func TestBusZeroAddress(t *testing.T) {
+	bus := newBus("DBUS")
+	ram := newMemory("RAM", 16)
+	bus.attach(MemRegion{0, 15}, &ram)
+	// default: read returns 0, write goes to device
+	bus.write(0, 0o77)
+	if bus.read(0) != 0 || ram.read(0) != 0o77 {
+		t.Error("Default zero address mode is changed")
+	}
+	if val, ok := bus.tryRead(0); !ok || val != 0 {
+		t.Error("Default zero address mode is changed for tryRead")
+	}
+	// ordinary cell
+	bus.zeroMode = zeroCell
+	if bus.read(0) != 0o77 {
+		t.Error("Zero address is not read from device")
+	}
+	if val, ok := bus.tryRead(0); !ok || val != 0o77 {
+		t.Error("Zero address is not read from device with tryRead")
+	}
+	// hardwired constant
+	bus.setConstants(0o1234)
+	bus.write(0, 0o55)
+	if !bus.tryWrite(0, 0o55) || ram.read(0) != 0o77 {
+		t.Error("Zero address constant is written")
+	}
+	if bus.read(0) != 0o1234 {
+		t.Error("Zero address constant is not read")
+	}
+	// constant register bank shadows device cells
+	ram.write(3, 0o33)
+	bus.setConstants(0, 1, 0o4050000000000000)
+	bus.write(2, 0o55)
+	if bus.read(1) != 1 || bus.read(2) != 0o4050000000000000 || ram.read(2) != 0 {
+		t.Error("Constant register bank failed")
+	}
+	if val, ok := bus.tryRead(2); !ok || val != 0o4050000000000000 || !bus.tryWrite(1, 0o55) {
+		t.Error("Constant register bank failed for tryRead and tryWrite")
+	}
+	if bus.read(3) != 0o33 {
+		t.Error("Address after constant register bank is not read from device")
+	}
+	// empty bank keeps address 0 constant zero
+	bus.setConstants()
+	if bus.read(0) != 0 || bus.read(1) != ram.read(1) {
+		t.Error("Empty constant register bank failed")
+	}
+	// unconnected zero address
+	empty := newBus("IBUS")
+	empty.zeroMode = zeroCell
+	if _, ok := empty.tryRead(0); ok {
+		t.Error("Zero address without device is read")
+	}
+}
+
+// waitConsole runs console for line delay, returns false if no input is
+// received
+func waitConsole(con *Console) bool {
+	con.tick(conDelay)
+	return con.read(conStatus)&conInReady != 0
+}
+func TestConsole(t *testing.T) {
+	var out strings.Builder
+	ic := newIntController()
+	con := newConsole("CONSOLE", strings.NewReader("Hi"), &out, asciiCharset{})
+	con.setInterrupt(ic, 8)
+	bus := newBus("DBUS")
+	bus.attach(MemRegion{0o100, 0o100 + conSize - 1}, &con)
+	bus.write(0o100+conControl, conInReady)
+	waitConsole(&con)
+	if bus.read(0o100+conStatus) != conOutReady|conInReady {
+		t.Error("Console input is not ready")
+	}
+	if line, ok := ic.acknowledge(); !ok || line != 8 {
+		t.Error("Console input interrupt is not raised")
+	}
+	for _, c := range "Hi" {
+		if !waitConsole(&con) || bus.read(0o100) != besmWord(c) {
+			t.Error("Console input failed")
+		}
+	}
+	if waitConsole(&con) || bus.read(0o100) != 0 {
+		t.Error("Console input after end of input")
+	}
+	if bus.read(0o100+conStatus) != conOutReady {
+		t.Error("Console input is ready after end of input")
+	}
+	for _, c := range "OK\n" {
+		bus.write(0o100, besmWord(c))
+	}
+	if out.String() != "OK\n" {
+		t.Errorf("Console output failed: %q", out.String())
+	}
+}
+
+func TestConsoleCharsets(t *testing.T) {
+	var out strings.Builder
+	con := newConsole("TTY", strings.NewReader("A1 B"), &out, &ita2Charset{})
+	var codes []besmWord
+	for waitConsole(&con) {
+		codes = append(codes, con.read(conData))
+	}
+	// A, FIGS, 1, space, LTRS, B
+	expected := []besmWord{0o3, ita2Figs, 0o27, 0o4, ita2Ltrs, 0o31}
+	if len(codes) != len(expected) {
+		t.Fatalf("ITA2 encoding failed: %o", codes)
+	}
+	for i := range codes {
+		if codes[i] != expected[i] {
+			t.Fatalf("ITA2 encoding failed: %o", codes)
+		}
+	}
+	for _, c := range codes {
+		con.write(conData, c)
+	}
+	if out.String() != "A1 B" {
+		t.Errorf("ITA2 decoding failed: %q", out.String())
+	}
+
+	out.Reset()
+	con = newConsole("PRINTER", nil, &out, gostCharset{})
+	for _, c := range (gostCharset{}).encode('h') {
+		con.write(conData, c)
+	}
+	con.write(conData, 0o42)
+	con.write(conData, 1)
+	if out.String() != "НВ1" {
+		t.Errorf("GOST decoding failed: %q", out.String())
+	}
+}
+
+func TestConsoleNoInput(t *testing.T) {
+	con := newConsole("CONSOLE", strings.NewReader("xy"), nil, asciiCharset{})
+	if con.read(conStatus) != conOutReady|conInReady || con.read(conData) != 'x' {
+		t.Error("Console host input is not received")
+	}
+	// next host character is not received before line delay is over
+	con.tick(conDelay - 1)
+	if con.read(conStatus) != conOutReady || con.read(conData) != 0 {
+		t.Error("Console input is ready before line delay")
+	}
+	con.tick(1)
+	if con.read(conStatus) != conOutReady|conInReady || con.read(conData) != 'y' {
+		t.Error("Console host input is not received after line delay")
+	}
+}
+
+func TestTimer(t *testing.T) {
+	tmr := newTimer("TIMER")
+	ic := newIntController()
+	tmr.setInterrupt(ic, 9)
+	tmr.write(tmrCount, 3)
+	tmr.write(tmrControl, tmrEnable|tmrPeriodic|tmrIntEnable)
+	tmr.tick(2)
+	if tmr.read(tmrCount) != 1 || tmr.read(tmrStatus) != 0 {
+		t.Error("Timer count down failed")
+	}
+	tmr.tick(1)
+	if tmr.read(tmrCount) != 3 || tmr.read(tmrStatus) != tmrExpired {
+		t.Error("Timer expiry failed")
+	}
+	if line, ok := ic.acknowledge(); !ok || line != 9 {
+		t.Error("Timer interrupt is not raised")
+	}
+	tmr.tick(6)
+	if tmr.read(tmrStatus) != tmrExpired|tmrOverrun {
+		t.Error("Timer overrun is not detected")
+	}
+	tmr.write(tmrStatus, tmrExpired|tmrOverrun)
+	// one shot timer stops at zero
+	tmr.write(tmrControl, tmrEnable)
+	tmr.tick(5)
+	if tmr.read(tmrCount) != 0 || tmr.read(tmrControl) != 0 || tmr.read(tmrStatus) != tmrExpired {
+		t.Error("One shot timer failed")
+	}
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	tmr := newTimer("TIMER")
+	ibus := newBus("IBUS")
+	ibus.attach(MemRegion{0, 1023}, &mem)
+	ibus.attach(MemRegion{0o2000, 0o2000 + tmrSize - 1}, &tmr)
+	cpu := newCPU(ibus, ibus)
+	tmr.setInterrupt(cpu.intc, 9)
+	// UTM 1(3) at left, UJ 1 at right
+	left, _ := emitOp(3, OpUTM, 1)
+	right, _ := emitOp(0, OpUJ, 1)
+	mem.write(1, left<<24|right)
+	tmr.write(tmrCount, 10)
+	tmr.write(tmrControl, tmrEnable|tmrIntEnable)
+	for i := 0; i < 10; i++ {
+		cpu.step()
+	}
+	if cpu.steps != 10 || cpu.M[3] != 5 || cpu.rrReg&0b1000000 != 0 {
+		t.Error("Timer interrupt raised too early")
+	}
+	cpu.step()
+	if cpu.PC != cpu.intBase+9 || cpu.rrReg&0b1000000 == 0 {
+		cpu.state()
+		t.Error("Timer interrupt is not served")
+	}
+}
+
+func TestDrum(t *testing.T) {
+	file, err := ioutil.TempFile("", "drum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	ram := newMemory("RAM", 1024)
+	dbus := newBus("DBUS")
+	dbus.attach(MemRegion{0, 1023}, &ram)
+	drm := newDrum("DRUM", file, newChannel("CH", dbus, 0), 4, 8, 16)
+	ic := newIntController()
+	drm.setInterrupt(ic, 10)
+	dbus.attach(MemRegion{0o2000, 0o2000 + drmSize - 1}, &drm)
+
+	for i := uint16(0); i < 20; i++ {
+		ram.write(0o100+i, besmWord(i)|0o7700000000000000)
+	}
+	dbus.write(0o2000+drmTrack, 2)
+	dbus.write(0o2000+drmSector, 7)
+	dbus.write(0o2000+drmAddr, 0o100)
+	dbus.write(0o2000+drmCount, 20)
+	dbus.write(0o2000+drmControl, drmDone)
+	dbus.write(0o2000+drmCommand, drmWrite)
+	if dbus.read(0o2000+drmStatus) != drmDone {
+		t.Error("Drum write failed")
+	}
+	if line, ok := ic.acknowledge(); !ok || line != 10 {
+		t.Error("Drum interrupt is not raised")
+	}
+	if info, _ := file.Stat(); info.Size() != (23*16+20)*drmWordBytes {
+		t.Errorf("Drum image size is %d", info.Size())
+	}
+	dbus.write(0o2000+drmStatus, drmDone)
+	dbus.write(0o2000+drmAddr, 0o200)
+	dbus.write(0o2000+drmCommand, drmRead)
+	for i := uint16(0); i < 20; i++ {
+		if ram.read(0o200+i) != ram.read(0o100+i) {
+			t.Fatal("Drum read failed")
+		}
+	}
+	// transfer beyond last sector
+	dbus.write(0o2000+drmTrack, 3)
+	dbus.write(0o2000+drmCount, 17)
+	dbus.write(0o2000+drmCommand, drmRead)
+	if dbus.read(0o2000+drmStatus) != drmDone|drmError {
+		t.Error("Drum transfer beyond end is not rejected")
+	}
+	// sectors never written are read as zeros
+	dbus.write(0o2000+drmTrack, 0)
+	dbus.write(0o2000+drmSector, 0)
+	dbus.write(0o2000+drmCount, 1)
+	dbus.write(0o2000+drmCommand, drmRead)
+	if dbus.read(0o2000+drmStatus) != drmDone || ram.read(0o200) != 0 {
+		t.Error("Drum read of empty sector failed")
+	}
+}
+
+func TestTape(t *testing.T) {
+	file, err := ioutil.TempFile("", "tape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	ram := newMemory("RAM", 1024)
+	dbus := newBus("DBUS")
+	dbus.attach(MemRegion{0, 1023}, &ram)
+	tap := newTape("TAPE", file, newChannel("CH", dbus, 0))
+	dbus.attach(MemRegion{0o2000, 0o2000 + tapSize - 1}, &tap)
+	command := func(cmd besmWord) besmWord {
+		dbus.write(0o2000+tapStatus, MASK48)
+		dbus.write(0o2000+tapCommand, cmd)
+		return dbus.read(0o2000 + tapStatus)
+	}
+
+	ram.write(0o100, 0o1234567076543210)
+	ram.write(0o101, 0o7777000000000001)
+	ram.write(0o102, 0o42)
+	dbus.write(0o2000+tapAddr, 0o100)
+	dbus.write(0o2000+tapCount, 2)
+	command(tapWrite)
+	command(tapWriteMark)
+	dbus.write(0o2000+tapAddr, 0o102)
+	dbus.write(0o2000+tapCount, 1)
+	command(tapWrite)
+	if info, _ := file.Stat(); info.Size() != 4+12+4+4+4+6+4+4 {
+		t.Errorf("Tape image size is %d", info.Size())
+	}
+	if command(tapRewind) != tapDone|tapBOT {
+		t.Error("Tape rewind failed")
+	}
+	dbus.write(0o2000+tapAddr, 0o200)
+	dbus.write(0o2000+tapCount, 10)
+	if command(tapRead) != tapDone {
+		t.Error("Tape read failed")
+	}
+	if dbus.read(0o2000+tapLength) != 2 || ram.read(0o200) != 0o1234567076543210 || ram.read(0o201) != 0o7777000000000001 {
+		t.Error("Tape record read failed")
+	}
+	if command(tapSkip) != tapDone|tapMark {
+		t.Error("Tape mark is not detected")
+	}
+	if command(tapSkip) != tapDone || command(tapSkip) != tapDone|tapEOT {
+		t.Error("Tape end is not detected")
+	}
+	command(tapSkipBack)
+	if command(tapSkipBack) != tapDone|tapMark {
+		t.Error("Tape mark is not detected on backward skip")
+	}
+	command(tapSkipBack)
+	if command(tapSkipBack) != tapDone|tapBOT {
+		t.Error("Tape load point is not detected")
+	}
+
+	// shorter record written over old ones ends recorded data
+	command(tapRewind)
+	dbus.write(0o2000+tapAddr, 0o102)
+	dbus.write(0o2000+tapCount, 1)
+	command(tapWrite)
+	command(tapRewind)
+	dbus.write(0o2000+tapAddr, 0o200)
+	if command(tapRead) != tapDone || ram.read(0o200) != 0o42 {
+		t.Error("Tape rewritten record read failed")
+	}
+	if command(tapRead) != tapDone|tapEOT {
+		t.Error("Tape end after rewritten record is not detected")
+	}
+
+	// odd length record written by other SIMH tools is padded
+	file.Truncate(0)
+	file.WriteAt([]byte{3, 0, 0, 0, 1, 2, 3, 0, 3, 0, 0, 0, 0, 0, 0, 0}, 0)
+	command(tapRewind)
+	dbus.write(0o2000+tapCount, 1)
+	if command(tapRead) != tapDone || ram.read(0o200) != 0x010203000000 {
+		t.Error("Tape odd record read failed")
+	}
+	if command(tapRead) != tapDone|tapMark {
+		t.Error("Tape mark after odd record is not detected")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	var out strings.Builder
+	ram := newMemory("RAM", 1024)
+	dbus := newBus("DBUS")
+	dbus.attach(MemRegion{0, 1023}, &ram)
+	lpr := newPrinter("LPR", &out, newChannel("CH", dbus, 0), asciiCharset{}, 3)
+	dbus.attach(MemRegion{0o2000, 0o2000 + lprSize - 1}, &lpr)
+	// "HELLO," "WORLD" packed by 6 8-bit characters
+	ram.write(0o100, 0x48454c4c4f2c)
+	ram.write(0o101, 0x20574f524c44)
+	dbus.write(0o2000+lprControl, lprWide)
+	dbus.write(0o2000+lprAddr, 0o100)
+	dbus.write(0o2000+lprCount, 2)
+	dbus.write(0o2000+lprCommand, lprPrint)
+	if dbus.read(0o2000+lprStatus) != lprDone {
+		t.Error("Printer status failed")
+	}
+	dbus.write(0o2000+lprSpace, 0)
+	dbus.write(0o2000+lprCount, 1)
+	dbus.write(0o2000+lprCommand, lprPrint)
+	// page ends after third line
+	dbus.write(0o2000+lprSpace, 2)
+	dbus.write(0o2000+lprCommand, lprSkip)
+	if dbus.read(0o2000+lprStatus) != lprDone|lprTOF {
+		t.Error("Printer page end failed")
+	}
+	dbus.write(0o2000+lprCommand, lprFormFeed)
+	// 6-bit GOST characters "НВ 12" padded by spaces
+	lpr.cs = gostCharset{}
+	ram.write(0o102, 0o5542170102171717)
+	dbus.write(0o2000+lprControl, 0)
+	dbus.write(0o2000+lprAddr, 0o102)
+	dbus.write(0o2000+lprSpace, 1)
+	dbus.write(0o2000+lprCommand, lprPrint)
+	dbus.write(0o2000+lprCommand, lprFormFeed)
+	if out.String() != "HELLO, WORLD\nHELLO,\r\n\n\fНВ 12\n\f" {
+		t.Errorf("Printer output failed: %q", out.String())
+	}
+	dbus.write(0o2000+lprCommand, 7)
+	if dbus.read(0o2000+lprStatus) != lprDone|lprError|lprTOF {
+		t.Error("Printer bad command is not rejected")
+	}
+}
+
+func TestCards(t *testing.T) {
+	ram := newMemory("RAM", 1024)
+	dbus := newBus("DBUS")
+	dbus.attach(MemRegion{0, 1023}, &ram)
+	deck := "HELLO, WORLD\n      X = 1.5 + A(I)\n"
+	ch := newChannel("CH", dbus, 0)
+	rdr := newCardReader("READER", strings.NewReader(deck), ch, deckText)
+	ic := newIntController()
+	rdr.setInterrupt(ic, 11)
+	dbus.attach(MemRegion{0o2000, 0o2000 + crdSize - 1}, &rdr)
+	dbus.write(0o2000+crdAddr, 0o100)
+	dbus.write(0o2000+crdCount, 3)
+	dbus.write(0o2000+crdControl, crdEmpty)
+	dbus.write(0o2000+crdCommand, crdStart)
+	if dbus.read(0o2000+crdCards) != 2 || dbus.read(0o2000+crdStatus) != crdDone|crdEmpty {
+		t.Error("Card reader failed")
+	}
+	if line, ok := ic.acknowledge(); !ok || line != 11 {
+		t.Error("Card reader interrupt is not raised")
+	}
+	// H is 12-8, E is 12-5, L is 11-3
+	if ram.read(0o100) != 0o4002402021002100 {
+		t.Errorf("Card image failed: %016o", ram.read(0o100))
+	}
+
+	var out strings.Builder
+	pun := newCardPunch("PUNCH", &out, ch, deckText)
+	dbus.attach(MemRegion{0o2010, 0o2010 + crdSize - 1}, &pun)
+	dbus.write(0o2010+crdAddr, 0o100)
+	dbus.write(0o2010+crdCount, 2)
+	dbus.write(0o2010+crdCommand, crdStart)
+	if dbus.read(0o2010+crdStatus) != crdDone || out.String() != deck {
+		t.Errorf("Card punch failed: %q", out.String())
+	}
+
+	// column binary deck
+	out.Reset()
+	pun.format = deckBinary
+	dbus.write(0o2010+crdCommand, crdStart)
+	if out.Len() != 2*2*cardColumns {
+		t.Error("Column binary punch failed")
+	}
+	rdr = newCardReader("READER", strings.NewReader(out.String()), ch, deckBinary)
+	rdr.write(crdAddr, 0o200)
+	rdr.write(crdCount, 2)
+	rdr.write(crdCommand, crdStart)
+	if rdr.read(crdStatus) != crdDone || rdr.read(crdCards) != 2 {
+		t.Error("Column binary read failed")
+	}
+	for i := uint16(0); i < 2*cardWords; i++ {
+		if ram.read(0o200+i) != ram.read(0o100+i) {
+			t.Fatal("Column binary deck differs")
+		}
+	}
+}
+
+func TestChannel(t *testing.T) {
+	file, err := ioutil.TempFile("", "drum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	mem := newMemory("MEM", 1024)
+	bus := newBus("BUS")
+	bus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(bus, bus)
+	ch := newChannel("CH", bus, 2)
+	cpu.addChannel(ch)
+	drm := newDrum("DRUM", file, ch, 1, 1, 8)
+	drm.setInterrupt(cpu.intc, 12)
+	bus.attach(MemRegion{0o2000, 0o2000 + drmSize - 1}, &drm)
+	// UTM 1(3) at left, UJ 1 at right
+	left, _ := emitOp(3, OpUTM, 1)
+	right, _ := emitOp(0, OpUJ, 1)
+	mem.write(1, left<<24|right)
+	for i := uint16(0); i < 5; i++ {
+		mem.write(0o100+i, besmWord(i+1))
+	}
+	drm.write(drmAddr, 0o100)
+	drm.write(drmCount, 5)
+	drm.write(drmControl, drmDone)
+	drm.write(drmCommand, drmWrite)
+	drm.write(drmCommand, drmRead)
+	if drm.read(drmStatus) != drmBusy|drmError {
+		t.Error("Drum busy status failed")
+	}
+	drm.write(drmStatus, drmError)
+	cpu.step()
+	cpu.step()
+	if drm.read(drmStatus) != drmBusy || !ch.busy() {
+		t.Error("Channel transfer completed too early")
+	}
+	cpu.step()
+	if drm.read(drmStatus) != drmDone || ch.busy() || cpu.stolen != 5 || cpu.M[3] != 2 {
+		t.Error("Channel transfer failed")
+	}
+	cpu.step()
+	if cpu.PC != cpu.intBase+12 {
+		t.Error("Channel completion interrupt is not served")
+	}
+	// read back two words of sector to memory
+	drm.write(drmAddr, 0o200)
+	drm.write(drmCount, 2)
+	drm.write(drmCommand, drmRead)
+	ch.tick()
+	if drm.read(drmStatus) != drmDone || mem.read(0o200) != 1 || mem.read(0o201) != 2 {
+		t.Error("Channel read failed")
+	}
+}
+
+func TestIntLevel(t *testing.T) {
+	ic := newIntController()
+	var il IntLine
+	// lines of machine faults are reserved
+	if il.setInterrupt(ic, faultBus) == nil || il.setInterrupt(ic, intLines) == nil {
+		t.Error("Reserved interrupt line is connected")
+	}
+	il.setInterrupt(ic, 8)
+	il.assert()
+	il.request()
+	for i := 0; i < 2; i++ {
+		if line, ok := ic.acknowledge(); !ok || line != 8 {
+			t.Error("Asserted line is not served")
+		}
+	}
+	il.deassert()
+	if _, ok := ic.acknowledge(); ok {
+		t.Error("Deasserted line is served")
+	}
+}
+
+func TestClockedConsole(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	con := newConsole("CONSOLE", strings.NewReader("A"), nil, asciiCharset{})
+	bus := newBus("BUS")
+	bus.attach(MemRegion{0, 1023}, &mem)
+	bus.attach(MemRegion{0o2000, 0o2000 + conSize - 1}, &con)
+	cpu := newCPU(bus, bus)
+	con.setInterrupt(cpu.intc, 8)
+	// UTM 1(3) at left, UJ 1 at right
+	left, _ := emitOp(3, OpUTM, 1)
+	right, _ := emitOp(0, OpUJ, 1)
+	mem.write(1, left<<24|right)
+	// interrupt handler: XTA 2000 (console data), IJ
+	xta, _ := emitOp(0, OpXTA, 0o2000)
+	ij, _ := emitOp(0, OpIJ, 0)
+	mem.write(cpu.intBase+8, xta<<24|ij)
+	cpu.rrReg = 0b10000
+	cpu.step()
+	if cpu.rrReg&0b1000000 != 0 {
+		t.Error("Console interrupt without input enabled")
+	}
+	waitConsole(&con)
+	con.write(conControl, conInReady)
+	cpu.step()
+	if cpu.PC != cpu.intBase+8 {
+		cpu.state()
+		t.Fatal("Console input interrupt is not served")
+	}
+	cpu.step()
+	cpu.step()
+	if cpu.Acc != 'A' || cpu.PC != 1 || cpu.rrReg&0b1000000 != 0 {
+		cpu.state()
+		t.Error("Console input is not received by interrupt handler")
+	}
+	// request is dropped after character is read
+	cpu.step()
+	if cpu.PC != 1 || cpu.M[3] != 2 {
+		cpu.state()
+		t.Error("Console interrupt is served after input is read")
+	}
+}
+
+func TestClockedConsoleWait(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	con := newConsole("CONSOLE", strings.NewReader("xy"), nil, asciiCharset{})
+	bus := newBus("BUS")
+	bus.attach(MemRegion{0, 1023}, &mem)
+	bus.attach(MemRegion{0o2000, 0o2000 + conSize - 1}, &con)
+	cpu := newCPU(bus, bus)
+	con.setInterrupt(cpu.intc, 8)
+	con.write(conControl, conInReady)
+	// UTM 1(3) at left, UJ 1 at right
+	left, _ := emitOp(3, OpUTM, 1)
+	right, _ := emitOp(0, OpUJ, 1)
+	mem.write(1, left<<24|right)
+	// interrupt handler: XTA 2000 (console data), IJ
+	xta, _ := emitOp(0, OpXTA, 0o2000)
+	ij, _ := emitOp(0, OpIJ, 0)
+	mem.write(cpu.intBase+8, xta<<24|ij)
+	cpu.rrReg = 0b10000
+	// first character is received at end of UTM, handler returns to UJ
+	for i := 0; i < 4; i++ {
+		cpu.step()
+	}
+	if cpu.Acc != 'x' || cpu.PC != 1 || cpu.rrReg&0b1000000 != 0 {
+		cpu.state()
+		t.Fatal("Console input is not received by interrupt handler")
+	}
+	// CPU runs while waiting for next character, it is received at end
+	// of line delay counted from XTA
+	for i := 0; i < conDelay-3; i++ {
+		cpu.step()
+	}
+	if cpu.rrReg&0b1000000 != 0 || cpu.M[3] != 50 {
+		cpu.state()
+		t.Error("Console input interrupt is served before line delay")
+	}
+	cpu.step()
+	if cpu.rrReg&0b1000000 == 0 || cpu.PC != cpu.intBase+8 {
+		cpu.state()
+		t.Error("Console input interrupt is not served after line delay")
+	}
+	cpu.step()
+	if cpu.Acc != 'y' {
+		t.Error("Console input is not received after line delay")
+	}
+}
+
+func TestTiming(t *testing.T) {
+	mem := newMemory("MEM", 1024)
+	bus := newBus("BUS")
+	bus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(bus, bus)
+	// UTM 1(3) at left, ASN 64+5 (shift right by 5) at right
+	left, _ := emitOp(3, OpUTM, 1)
+	right, _ := emitOp(0, OpASN, 0o105)
+	mem.write(1, left<<24|right)
+	stop, _ := emitOp(0, OpSTOP, 0)
+	mem.write(2, stop<<24)
+	cpu.step()
+	if cpu.cycles != fetchCycles+opCycles[OpUTM] {
+		t.Errorf("UTM takes %d cycles", cpu.cycles)
+	}
+	cpu.step()
+	if cpu.cycles != fetchCycles+opCycles[OpUTM]+opCycles[OpASN]+5*shiftCycles {
+		t.Errorf("ASN takes %d cycles", cpu.cycles-fetchCycles-opCycles[OpUTM])
+	}
+	cpu.run()
+	total := 2*fetchCycles + opCycles[OpUTM] + opCycles[OpASN] + 5*shiftCycles + opCycles[OpSTOP]
+	if cpu.cycles != total || cpu.simTime() != time.Duration(total)*cycleTime {
+		t.Errorf("Program takes %d cycles", cpu.cycles)
+	}
+	if !strings.Contains(cpu.timing(), "3 instructions") {
+		t.Errorf("Timing report failed: %s", cpu.timing())
+	}
+	// report is logged at STOP executed by step
+	var report strings.Builder
+	log.SetOutput(&report)
+	cpu.reset()
+	mem.write(1, stop<<24)
+	cpu.step()
+	log.SetOutput(ioutil.Discard)
+	if !strings.Contains(report.String(), "1 instructions, 4 cycles") {
+		t.Errorf("Timing is not reported at STOP: %q", report.String())
+	}
+	// YTA normalization shifts mantissa 1 left by 39 positions
+	cpu.reset()
+	cpu.rrReg = 0b10000
+	cpu.Acc = 0o4000000000000000
+	cpu.Rmr = 1
+	yta, _ := emitOp(0, OpYTA, 0o100)
+	mem.write(1, yta<<24)
+	cpu.step()
+	if cpu.cycles != fetchCycles+opCycles[OpYTA]+39*shiftCycles {
+		t.Errorf("YTA takes %d cycles", cpu.cycles-fetchCycles)
+	}
+}
+
+func TestChannelImmediate(t *testing.T) {
+	var out strings.Builder
+	mem := newMemory("MEM", 1024)
+	bus := newBus("BUS")
+	bus.attach(MemRegion{0, 1023}, &mem)
+	cpu := newCPU(bus, bus)
+	ch := newChannel("CH", bus, 0)
+	cpu.addChannel(ch)
+	lpr := newPrinter("LPR", &out, ch, asciiCharset{}, 0)
+	lpr.write(lprControl, lprWide)
+	mem.write(0o100, 0x4f4b)
+	lpr.write(lprAddr, 0o100)
+	lpr.write(lprCount, 3)
+	lpr.write(lprCommand, lprPrint)
+	if out.String() != "OK\n" || lpr.read(lprStatus) != lprDone {
+		t.Errorf("Printer output failed: %q", out.String())
+	}
+	// cycles of whole block transfer are charged on next step
+	utm, _ := emitOp(3, OpUTM, 1)
+	mem.write(1, utm<<24)
+	cpu.step()
+	if cpu.stolen != 3 || cpu.cycles != fetchCycles+opCycles[OpUTM]+3 {
+		t.Errorf("Channel stole %d cycles", cpu.stolen)
+	}
+	cpu.step()
+	if cpu.stolen != 3 {
+		t.Error("Channel cycles are charged twice")
+	}
+
+	// reset aborts transfer of previous program
+	slow := newChannel("SLOW", bus, 1)
+	cpu.addChannel(slow)
+	lpr = newPrinter("LPR", &out, slow, asciiCharset{}, 0)
+	lpr.write(lprCount, 3)
+	lpr.write(lprCommand, lprPrint)
+	if lpr.read(lprStatus)&lprBusy == 0 {
+		t.Error("Printer is not busy")
+	}
+	cpu.reset()
+	cpu.step()
+	if slow.busy() || cpu.stolen != 0 || lpr.read(lprStatus)&(lprBusy|lprError) != lprError {
+		t.Error("Reset does not abort channel transfer")
+	}
+	if out.String() != "OK\n" {
+		t.Errorf("Aborted line is printed: %q", out.String())
+	}
+	// completion of aborted transfer does not request interrupt
+	lpr.setInterrupt(cpu.intc, 9)
+	lpr.write(lprControl, lprIntEnable)
+	lpr.write(lprCommand, lprPrint)
+	cpu.reset()
+	if _, ok := cpu.intc.acknowledge(); ok {
+		t.Error("Interrupt is pending after reset")
+	}
+	// channel which is not advanced by CPU fails transfers
+	lpr = newPrinter("LPR", &out, newChannel("LOST", bus, 1), asciiCharset{}, 0)
+	lpr.write(lprCount, 3)
+	lpr.write(lprCommand, lprPrint)
+	if lpr.read(lprStatus)&(lprBusy|lprError) != lprError {
+		t.Error("Transfer on channel without CPU does not fail")
+	}
+}
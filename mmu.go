@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 15-bit address space is split to 32 pages of 1024 words
+const (
+	pageBits  = 10
+	pageSize  = 1 << pageBits
+	pageCount = 32
+	pageValid = 0o40 // page register valid bit, low 5 bits is physical page
+)
+
+// MMU translates virtual addresses of user mode programs to physical
+// bus addresses using page registers
+type MMU struct {
+	enabled bool
+	pages   [pageCount]uint16
+}
+
+func newMMU() *MMU {
+	return &MMU{}
+}
+
+func (mmu *MMU) reset() {
+	mmu.pages = [pageCount]uint16{}
+}
+
+// setPage maps virtual page to physical page
+func (mmu *MMU) setPage(vpage uint16, ppage uint16) {
+	mmu.pages[vpage%pageCount] = ppage%pageCount | pageValid
+}
+
+// unmapPage makes access to virtual page fault
+func (mmu *MMU) unmapPage(vpage uint16) {
+	mmu.pages[vpage%pageCount] = 0
+}
+
+// translate returns physical address or false for unmapped page
+func (mmu *MMU) translate(addr uint16) (uint16, bool) {
+	reg := mmu.pages[(addr>>pageBits)%pageCount]
+	if reg&pageValid == 0 {
+		return 0, false
+	}
+	return (reg%pageCount)<<pageBits | addr%pageSize, true
+}
+
+// String lists mapped pages
+func (mmu *MMU) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "MMU enabled: %t\n", mmu.enabled)
+	for vpage, reg := range mmu.pages {
+		if reg&pageValid != 0 {
+			ppage := reg % pageCount
+			fmt.Fprintf(&sb, "%05o-%05o -> %05o-%05o\n", vpage<<pageBits, vpage<<pageBits+pageSize-1,
+				ppage<<pageBits, ppage<<pageBits+pageSize-1)
+		}
+	}
+	return sb.String()
+}
@@ -114,6 +114,15 @@ const (
 
 type besmWord uint64
 
+// isPrivileged reports whether opcode is not allowed in user mode
+func isPrivileged(op uint16) bool {
+	switch op {
+	case OpNTR, OpXTR, OpSTOP, OpIJ, OpE32, OpE33:
+		return true
+	}
+	return false
+}
+
 func emitOp(ind uint16, op uint16, addr uint16) (word besmWord, err error) {
 	word = besmWord(ind&0xF) << 20
 	addr = addr & MASK15
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// printer registers
+const (
+	lprAddr    = 0 // memory address of line buffer
+	lprCount   = 1 // words in line buffer
+	lprSpace   = 2 // lines to advance after print, 0 overprints next line
+	lprCommand = 3 // write starts command
+	lprControl = 4 // mode and interrupt enable bits
+	lprStatus  = 5 // read: status bits, write: clear written status bits
+	lprSize    = 6
+)
+
+// printer commands
+const (
+	lprPrint    = 1 // print line buffer and advance paper
+	lprFormFeed = 2 // advance paper to top of next page
+	lprSkip     = 3 // advance paper by space register lines
+)
+
+// printer control bits
+const (
+	lprWide      = 1 // 8-bit characters, 6 per word; otherwise 6-bit, 8 per word
+	lprIntEnable = 2 // raise interrupt when command is done
+)
+
+// printer status bits
+const (
+	lprDone  = 1 // command completed
+	lprError = 2 // bad command, memory address or output error
+	lprTOF   = 4 // paper is at top of form
+	lprBusy  = 8 // line buffer is being transferred
+)
+
+// Printer is a line printer writing pages to host text file
+type Printer struct {
+	name    string
+	out     io.Writer
+	ch      *Channel
+	cs      charset
+	pageLen int // lines per page, 0 disables paging
+	line    int // current line on page
+	addr    besmWord
+	count   besmWord
+	space   besmWord
+	control besmWord
+	status  besmWord
+	IntLine
+}
+
+func newPrinter(name string, out io.Writer, ch *Channel, cs charset, pageLen int) Printer {
+	return Printer{name: name, out: out, ch: ch, cs: cs, pageLen: pageLen, space: 1, status: lprTOF}
+}
+
+func (lpr *Printer) reset() {
+	lpr.addr = 0
+	lpr.count = 0
+	lpr.space = 1
+	lpr.control = 0
+	lpr.status &= lprTOF
+}
+
+func (lpr *Printer) getName() string {
+	return lpr.name
+}
+
+func (lpr *Printer) getSize() uint16 {
+	return lprSize
+}
+
+func (lpr *Printer) read(addr uint16) besmWord {
+	switch addr {
+	case lprAddr:
+		return lpr.addr
+	case lprCount:
+		return lpr.count
+	case lprSpace:
+		return lpr.space
+	case lprControl:
+		return lpr.control
+	case lprStatus:
+		return lpr.status
+	}
+	return 0
+}
+
+func (lpr *Printer) write(addr uint16, value besmWord) {
+	switch addr {
+	case lprAddr:
+		lpr.addr = value & MASK15
+	case lprCount:
+		lpr.count = value & MASK15
+	case lprSpace:
+		lpr.space = value & MASK15
+	case lprCommand:
+		lpr.execute(value)
+	case lprControl:
+		lpr.control = value & (lprWide | lprIntEnable)
+	case lprStatus:
+		lpr.status &^= value
+	}
+}
+
+// execute runs printer command, line is printed when line buffer
+// transfer on channel is completed
+func (lpr *Printer) execute(cmd besmWord) {
+	if lpr.status&lprBusy != 0 {
+		lpr.status |= lprError
+		return
+	}
+	switch cmd {
+	case lprPrint:
+		lpr.status |= lprBusy
+		lpr.ch.fromMemory(uint16(lpr.addr), int(lpr.count), func(words []besmWord, ok bool) {
+			if !ok {
+				lpr.finish(false, nil)
+				return
+			}
+			lpr.finish(true, lpr.print(words))
+		})
+	case lprFormFeed:
+		lpr.finish(true, lpr.formFeed())
+	case lprSkip:
+		lpr.finish(true, lpr.advance(int(lpr.space)))
+	default:
+		lpr.finish(false, nil)
+	}
+}
+
+// finish reports command result
+func (lpr *Printer) finish(ok bool, err error) {
+	lpr.status &^= lprDone | lprError | lprTOF | lprBusy
+	lpr.status |= lprDone
+	if !ok || err != nil {
+		lpr.status |= lprError
+	}
+	if lpr.line == 0 {
+		lpr.status |= lprTOF
+	}
+	if lpr.control&lprIntEnable != 0 {
+		lpr.request()
+	}
+}
+
+// print prints line buffer and advances paper
+func (lpr *Printer) print(words []besmWord) error {
+	if _, err := io.WriteString(lpr.out, lpr.text(words)); err != nil {
+		return err
+	}
+	if lpr.space == 0 {
+		_, err := io.WriteString(lpr.out, "\r")
+		return err
+	}
+	return lpr.advance(int(lpr.space))
+}
+
+// text unpacks characters of line buffer, most significant first
+func (lpr *Printer) text(words []besmWord) string {
+	bits, perWord := uint(6), 8
+	if lpr.control&lprWide != 0 {
+		bits, perWord = 8, 6
+	}
+	var sb strings.Builder
+	for _, word := range words {
+		for j := perWord - 1; j >= 0; j-- {
+			code := word >> (uint(j) * bits) & (1<<bits - 1)
+			if r, ok := lpr.cs.decode(code); ok && r != '\n' && r != 0 {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return strings.TrimRight(sb.String(), " \x00")
+}
+
+// advance feeds paper by n lines, starting new page after last line
+func (lpr *Printer) advance(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := io.WriteString(lpr.out, "\n"); err != nil {
+			return err
+		}
+		lpr.line++
+		if lpr.pageLen > 0 && lpr.line >= lpr.pageLen {
+			if err := lpr.formFeed(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formFeed feeds paper to top of next page
+func (lpr *Printer) formFeed() error {
+	if lpr.line == 0 {
+		return nil
+	}
+	lpr.line = 0
+	_, err := io.WriteString(lpr.out, "\f")
+	return err
+}
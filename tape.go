@@ -0,0 +1,299 @@
+package main
+
+import "encoding/binary"
+
+// tape registers
+const (
+	tapAddr    = 0 // memory address of transfer
+	tapCount   = 1 // words to write or maximum words to read
+	tapLength  = 2 // words in last read record
+	tapCommand = 3 // write starts command
+	tapControl = 4 // interrupt enable bits
+	tapStatus  = 5 // read: status bits, write: clear written status bits
+	tapSize    = 6
+)
+
+// tape commands
+const (
+	tapRead      = 1 // read next record to memory
+	tapWrite     = 2 // write record from memory
+	tapWriteMark = 3 // write tape mark
+	tapRewind    = 4 // rewind to load point
+	tapSkip      = 5 // skip record forward
+	tapSkipBack  = 6 // skip record backward
+)
+
+// tape status bits
+const (
+	tapDone  = 1    // command completed
+	tapError = 2    // bad command, memory address or damaged record
+	tapMark  = 4    // tape mark is passed
+	tapBOT   = 8    // tape is at load point
+	tapEOT   = 0o20 // end of recorded data
+	tapBusy  = 0o40 // data transfer is in progress
+)
+
+// SIMH .tap container: every record is framed by 32 bit little endian
+// byte count before and after data, data is padded to even length, zero
+// count is tape mark
+const (
+	tapeMarkLen   = 0
+	tapeEndMedium = 0xFFFFFFFF
+	tapeErrorBit  = 0x80000000
+	tapeLenMask   = 0x00FFFFFF
+)
+
+// bytes per word in tape records, big endian
+const tapWordBytes = 6
+
+// Tape is a magnetic tape drive with SIMH .tap image file, records are
+// moved to and from memory through channel
+type Tape struct {
+	name    string
+	file    blockFile
+	ch      *Channel
+	pos     int64 // position in image file
+	addr    besmWord
+	count   besmWord
+	length  besmWord
+	command besmWord
+	control besmWord
+	status  besmWord
+	IntLine
+}
+
+func newTape(name string, file blockFile, ch *Channel) Tape {
+	return Tape{name: name, file: file, ch: ch, status: tapBOT}
+}
+
+func (tap *Tape) reset() {
+	tap.addr = 0
+	tap.count = 0
+	tap.length = 0
+	tap.command = 0
+	tap.control = 0
+	tap.status &= tapBOT
+}
+
+func (tap *Tape) getName() string {
+	return tap.name
+}
+
+func (tap *Tape) getSize() uint16 {
+	return tapSize
+}
+
+func (tap *Tape) read(addr uint16) besmWord {
+	switch addr {
+	case tapAddr:
+		return tap.addr
+	case tapCount:
+		return tap.count
+	case tapLength:
+		return tap.length
+	case tapCommand:
+		return tap.command
+	case tapControl:
+		return tap.control
+	case tapStatus:
+		return tap.status
+	}
+	return 0
+}
+
+func (tap *Tape) write(addr uint16, value besmWord) {
+	switch addr {
+	case tapAddr:
+		tap.addr = value & MASK15
+	case tapCount:
+		tap.count = value & MASK15
+	case tapCommand:
+		tap.command = value
+		tap.execute(value)
+	case tapControl:
+		tap.control = value & (tapDone | tapError | tapMark | tapEOT)
+	case tapStatus:
+		tap.status &^= value
+	}
+}
+
+// execute runs tape command, result of data transfer is reported when
+// block transfer on channel is completed
+func (tap *Tape) execute(cmd besmWord) {
+	if tap.status&tapBusy != 0 {
+		tap.status |= tapError
+		return
+	}
+	tap.status &^= tapDone | tapError | tapMark | tapEOT
+	var status besmWord
+	switch cmd {
+	case tapRead:
+		var words []besmWord
+		words, status = tap.readRecord()
+		if words != nil {
+			tap.length = besmWord(len(words))
+			if len(words) > int(tap.count) {
+				words = words[:tap.count]
+			}
+			tap.status |= tapBusy
+			tap.ch.toMemory(uint16(tap.addr), words, func(ok bool) {
+				if !ok {
+					status |= tapError
+				}
+				tap.finish(status)
+			})
+			return
+		}
+		tap.finish(status)
+		return
+	case tapSkip:
+		_, status = tap.readRecord()
+	case tapWrite:
+		if tap.count == 0 {
+			status = tapError
+			break
+		}
+		tap.status |= tapBusy
+		tap.ch.fromMemory(uint16(tap.addr), int(tap.count), func(words []besmWord, ok bool) {
+			if !ok {
+				tap.finish(tapError)
+				return
+			}
+			tap.finish(tap.writeRecord(words))
+		})
+		return
+	case tapWriteMark:
+		status = tap.writeMark()
+	case tapRewind:
+		tap.pos = 0
+	case tapSkipBack:
+		status = tap.skipBack()
+	default:
+		status = tapError
+	}
+	tap.finish(status)
+}
+
+// finish reports command result
+func (tap *Tape) finish(status besmWord) {
+	tap.status &^= tapBusy
+	if tap.pos == 0 {
+		status |= tapBOT
+	} else {
+		tap.status &^= tapBOT
+	}
+	tap.status |= tapDone | status
+	if tap.control&(tapDone|status) != 0 {
+		tap.request()
+	}
+}
+
+func (tap *Tape) readLen(off int64) (uint32, bool) {
+	var buf [4]byte
+	if _, err := tap.file.ReadAt(buf[:], off); err != nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(buf[:]), true
+}
+
+func (tap *Tape) writeLen(off int64, n uint32) bool {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], n)
+	_, err := tap.file.WriteAt(buf[:], off)
+	return err == nil
+}
+
+// readRecord reads next record, returns nil words at tape mark and end
+// of tape
+func (tap *Tape) readRecord() ([]besmWord, besmWord) {
+	hdr, ok := tap.readLen(tap.pos)
+	if !ok || hdr == tapeEndMedium {
+		return nil, tapEOT
+	}
+	if hdr == tapeMarkLen {
+		tap.pos += 4
+		return nil, tapMark
+	}
+	n := int64(hdr & tapeLenMask)
+	data := make([]byte, n)
+	if _, err := tap.file.ReadAt(data, tap.pos+4); err != nil {
+		return nil, tapError | tapEOT
+	}
+	tap.pos += 4 + n + n&1 + 4
+	var status besmWord
+	if hdr&tapeErrorBit != 0 {
+		status = tapError
+	}
+	words := make([]besmWord, (n+tapWordBytes-1)/tapWordBytes)
+	for i := range words {
+		for j := 0; j < tapWordBytes; j++ {
+			words[i] <<= 8
+			if k := int64(i*tapWordBytes + j); k < n {
+				words[i] |= besmWord(data[k])
+			}
+		}
+	}
+	return words, status
+}
+
+// writeRecord writes words as record at current position
+func (tap *Tape) writeRecord(words []besmWord) besmWord {
+	n := int64(len(words)) * tapWordBytes
+	data := make([]byte, n)
+	for i, word := range words {
+		for j := tapWordBytes - 1; j >= 0; j-- {
+			data[i*tapWordBytes+j] = byte(word)
+			word >>= 8
+		}
+	}
+	if !tap.writeLen(tap.pos, uint32(n)) {
+		return tapError
+	}
+	if _, err := tap.file.WriteAt(data, tap.pos+4); err != nil {
+		return tapError
+	}
+	if !tap.writeLen(tap.pos+4+n, uint32(n)) {
+		return tapError
+	}
+	tap.pos += 4 + n + 4
+	return tap.writeEnd()
+}
+
+func (tap *Tape) writeMark() besmWord {
+	if !tap.writeLen(tap.pos, tapeMarkLen) {
+		return tapError
+	}
+	tap.pos += 4
+	return tap.writeEnd()
+}
+
+// writeEnd marks end of recorded data after written record, so records
+// left from previous recording are not read
+func (tap *Tape) writeEnd() besmWord {
+	if !tap.writeLen(tap.pos, tapeEndMedium) {
+		return tapError
+	}
+	return 0
+}
+
+// skipBack moves tape to beginning of previous record
+func (tap *Tape) skipBack() besmWord {
+	if tap.pos < 4 {
+		return 0
+	}
+	trl, ok := tap.readLen(tap.pos - 4)
+	if !ok {
+		return tapError
+	}
+	if trl == tapeMarkLen {
+		tap.pos -= 4
+		return tapMark
+	}
+	n := int64(trl & tapeLenMask)
+	tap.pos -= 4 + n + n&1 + 4
+	if tap.pos < 0 {
+		tap.pos = 0
+		return tapError
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+// timer registers
+const (
+	tmrCount   = 0 // read: current count, write: load count and reload value
+	tmrControl = 1 // mode bits
+	tmrStatus  = 2 // read: status bits, write: clear written status bits
+	tmrSize    = 3
+)
+
+// timer control bits
+const (
+	tmrEnable    = 1 // count down executed instructions
+	tmrPeriodic  = 2 // reload count on expiry instead of stop
+	tmrIntEnable = 4 // raise interrupt on expiry
+)
+
+// timer status bits
+const (
+	tmrExpired = 1 // count reached zero
+	tmrOverrun = 2 // count reached zero while expired bit was set
+)
+
+// Timer is an interval timer counting down executed instructions, so
+// simulation stays deterministic
+type Timer struct {
+	name    string
+	reload  besmWord
+	count   besmWord
+	control besmWord
+	status  besmWord
+	IntLine
+}
+
+func newTimer(name string) Timer {
+	return Timer{name: name}
+}
+
+func (tmr *Timer) reset() {
+	tmr.reload = 0
+	tmr.count = 0
+	tmr.control = 0
+	tmr.status = 0
+}
+
+func (tmr *Timer) getName() string {
+	return tmr.name
+}
+
+func (tmr *Timer) getSize() uint16 {
+	return tmrSize
+}
+
+// tick advances timer by n executed instructions
+func (tmr *Timer) tick(n uint64) {
+	for tmr.control&tmrEnable != 0 && n > 0 {
+		if uint64(tmr.count) > n {
+			tmr.count -= besmWord(n)
+			return
+		}
+		n -= uint64(tmr.count)
+		tmr.expire()
+	}
+}
+
+func (tmr *Timer) expire() {
+	if tmr.status&tmrExpired != 0 {
+		tmr.status |= tmrOverrun
+	}
+	tmr.status |= tmrExpired
+	if tmr.control&tmrIntEnable != 0 {
+		tmr.request()
+	}
+	if tmr.control&tmrPeriodic != 0 && tmr.reload != 0 {
+		tmr.count = tmr.reload
+	} else {
+		tmr.count = 0
+		tmr.control &^= tmrEnable
+	}
+}
+
+func (tmr *Timer) read(addr uint16) besmWord {
+	switch addr {
+	case tmrCount:
+		return tmr.count
+	case tmrControl:
+		return tmr.control
+	case tmrStatus:
+		return tmr.status
+	}
+	return 0
+}
+
+func (tmr *Timer) write(addr uint16, value besmWord) {
+	switch addr {
+	case tmrCount:
+		tmr.reload = value & MASK48
+		tmr.count = tmr.reload
+	case tmrControl:
+		tmr.control = value & (tmrEnable | tmrPeriodic | tmrIntEnable)
+		if tmr.control&tmrEnable != 0 && tmr.count == 0 {
+			tmr.expire()
+		}
+	case tmrStatus:
+		tmr.status &^= value
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing model is approximate: instruction costs below are estimates
+// keeping relative speed of instructions (memory transfers are cheap,
+// MUL and DIV are slow), they are not taken from BESM-6 documentation.
+
+// duration of one machine cycle
+const cycleTime = 100 * time.Nanosecond
+
+// data dependent costs in cycles
+const (
+	fetchCycles     = 2 // instruction word fetch, once per two instructions
+	shiftCycles     = 1 // one position of mantissa alignment, normalization or ASN/ASX shift
+	interruptCycles = 4 // interrupt entry
+)
+
+// cycles spent by extracode trap and other unlisted opcodes
+const defaultCycles = 6
+
+// opCycles holds fixed cost of instructions in cycles indexed by opcode,
+// unlisted opcodes (extracodes) take defaultCycles
+var opCycles = func() [0o400]uint64 {
+	var table [0o400]uint64
+	for op := range table {
+		table[op] = defaultCycles
+	}
+	for op, c := range map[uint16]uint64{
+		OpATX: 3, OpSTX: 3, OpXTS: 3, OpXTA: 3, OpMOD: 3,
+		OpADD: 5, OpSUB: 5, OpRSUB: 5, OpAMX: 5,
+		OpAAX: 3, OpAEX: 3, OpAOX: 3, OpARX: 4, OpAVX: 4,
+		OpAPX: 8, OpAUX: 8, OpACX: 4, OpANX: 4,
+		OpMUL: 12, OpDIV: 40,
+		OpEADDX: 4, OpESUBX: 4, OpEADDN: 4, OpESUB: 4,
+		OpASX: 3, OpASN: 3, OpYTA: 3,
+		OpXTR: 3, OpRTE: 3, OpNTR: 2, OpE32: 3, OpE33: 3,
+		OpATI: 2, OpSTI: 3, OpITA: 2, OpITS: 3, OpMTJ: 2, OpJADDM: 2,
+		OpUTC: 1, OpWTC: 3, OpVTM: 2, OpUTM: 2,
+		OpUZA: 2, OpUIA: 2, OpUJ: 2, OpVJM: 2, OpIJ: 3, OpSTOP: 2,
+		OpVZM: 2, OpVIM: 2, OpVLM: 2,
+	} {
+		table[op] = c
+	}
+	return table
+}()
+
+// simTime returns simulated execution time of counted cycles
+func (cpu *CPU) simTime() time.Duration {
+	return time.Duration(cpu.cycles) * cycleTime
+}
+
+// timing returns report of executed instructions and simulated time
+func (cpu *CPU) timing() string {
+	return fmt.Sprintf("%d instructions, %d cycles (%d stolen), simulated time %v",
+		cpu.steps, cpu.cycles, cpu.stolen, cpu.simTime())
+}
@@ -53,7 +53,7 @@ func loadOct(filename string, ibus *Bus, dbus *Bus) {
 				rightWord, _ = emitOp(rind, uint16(opcode), raddr)
 			}
 			word |= rightWord
-			ibus.write(iaddr, word)
+			ibus.program(iaddr, word)
 			// log.Printf("IBUS written at %05o data: %016o", iaddr, word)
 		}
 		if strings.HasPrefix(t, "d") {
@@ -64,7 +64,7 @@ func loadOct(filename string, ibus *Bus, dbus *Bus) {
 				continue
 			}
 			word = (besmWord(d3) << 36) | (besmWord(d2) << 24) | (besmWord(d1) << 12) | besmWord(d0)
-			dbus.write(daddr, word)
+			dbus.program(daddr, word)
 			// log.Printf("DBUS written at %05o data: %016o", daddr, word)
 		}
 	}